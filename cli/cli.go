@@ -14,6 +14,7 @@ import (
 type UserOption struct {
 	appendArgs           string              // not exported, content decoded into ExtraArgs
 	AutoComplete         bool                // generates an autocomplete response
+	AutoPort             bool                // starts services on a free port if their usual one is taken (use with --start)
 	CheckPorts           bool                // finds duplicate ports
 	Clean                bool                // used with --start to force re-downloading
 	CompWordCount        int                 // used with --autocomplete number of words in completion
@@ -21,39 +22,62 @@ type UserOption struct {
 	Config               string              // uses a different service-manager-config folder
 	Debug                string              // debug info about a service, used to determine why it failed to start
 	Diagnostic           bool                // runs tests to determine if there are problems with the install
+	Env                  EnvVars             // environment variables to set, by service. "" applies to every service (use with --start)
+	ExplicitFlags        map[string]bool     // the flags that were set on the command line, these take precedence over sm2.json
 	ExtraArgs            map[string][]string // parsed from content of AppendArgs
 	ExtraServices        []string            // ids of services to start
+	Force                bool                // used with --stop to kill services straight away rather than shutting them down gracefully
+	FromFile             string              // used with --start to install from a local tarball rather than artifactory
+	FromLock             string              // used with --start to start the services recorded by --snapshot
 	FromSource           bool                // used with --start to run from source rather than bin
+	Format               string              // sets the output format of --status, --list etc, either plain or json
 	FormatPlain          bool                // flag for setting enabling machine friendly/undecorated output
 	GenerateAutoComplete bool                // generates an autocomplete script
 	Latest               bool                // used in conjunction with --restart to check for latest version of service(s) being restarted
+	MaxRestarts          int                 // overrides how many times --supervise will restart a service before giving up
 	List                 bool                // lists all the services
+	Follow               bool                // keeps printing the logs as they're written (use with --logs)
+	Grep                 string              // only shows log lines matching a regex (use with --logs)
+	KeepVersions         int                 // with --clean-cache, only deletes versions older than the newest n of each service
+	Lines                int                 // only shows the last n lines of the logs (use with --logs)
 	Logs                 string              // prints the logs of a service, running or otherwise
 	NoPortCheck          bool                // stops the `lsof` port check
 	NoProgress           bool                // hides the animated download progress meter
 	NoVpnCheck           bool                // skips checking if vpn is connected before starting a service
 	Offline              bool                // prints downloaded services, used with --start bypasses download and uses local copy
 	Port                 int                 // overrides service port, only works with the first service when starting multiple
+	ServicePorts         map[string]int      // overrides the ports of specific services, from --port SERVICE=PORT
+	PortRange            string              // the range of ports --auto-port picks from, e.g. 20000-20999
 	Ports                bool                // prints all the ports
+	Previous             int                 // shows an older log, 1 being the most recent (use with --logs)
 	Prune                bool                // deletes .state files of services with a status of FAIL
 	CleanCache           bool                // deletes all cached services
 	Release              string              // specify a version when starting one service. unlikely old sm, cannot be used without a version
 	Restart              bool                // restarts a service or profile
 	RestartOutdated      bool                // restarts services running outdated versions
+	RestartPolicy        string              // overrides when --supervise should restart a service, never, on-failure or always
 	ReverseProxy         bool                // starts a reverse-proxy on 3000 (override with --port)
 	Search               string              // searches for services/profiles
+	Serve                bool                // runs sm2 as a server with a local http api (override port with --port)
+	ShowConfig           bool                // prints the effective settings and where they came from
+	Snapshot             bool                // prints a lock file of the running services, for use with --from-lock
+	Since                string              // only shows log lines written after a given time or duration (use with --logs)
 	Start                bool                // starts a service, multiple services or a profile(s)
 	Status               bool                // shows status of everything that's running
 	StatusShort          bool                // same as --status but is the -s short version of the cmd
 	StopAll              bool                // stops all the services that are running
+	Supervise            bool                // keeps running, restarting services based on their restart policy
+	StopTimeout          int                 // how many secs to wait for a service to shutdown gracefully before killing it
 	Stop                 bool                // stops a service, multiple services or profile(s)
 	Update               bool                // update sm2 if a newer version is available
 	UpdateConfig         bool                // pulls the latest copy of service-manager-config
 	Verbose              bool                // shows extra logging
 	Version              bool                // prints sm2 version number
 	Verify               bool                // checks if a given service or profile is running
+	VerifyInstalls       bool                // re-hashes the installed services to check they haven't changed
 	Wait                 int                 // waits a given number of secs (default 30) after starting a service for it to respond to healthcheck
 	Workers              int                 // sets the number of concurrent downloads/service starts
+	WriteLog             string              // used internally, writes stdin to the given log file rotating it as required
 }
 
 func Parse(args []string) (*UserOption, error) {
@@ -83,6 +107,34 @@ func Parse(args []string) (*UserOption, error) {
 		}
 	}
 
+	opts.ExplicitFlags = map[string]bool{}
+	flagset.Visit(func(f *flag.Flag) {
+		opts.ExplicitFlags[f.Name] = true
+	})
+
+	switch opts.Format {
+	case "":
+	case "plain":
+		opts.FormatPlain = true
+	case "json":
+	default:
+		return nil, fmt.Errorf("invalid --format %s, expected plain or json", opts.Format)
+	}
+
+	if opts.KeepVersions < 0 {
+		return nil, fmt.Errorf("invalid --keep-versions %d, must be >= 0", opts.KeepVersions)
+	}
+
+	if opts.FromLock != "" && !opts.Start {
+		return nil, fmt.Errorf("--from-lock can only be used with --start")
+	}
+
+	switch opts.RestartPolicy {
+	case "", "never", "on-failure", "always":
+	default:
+		return nil, fmt.Errorf("invalid --restart-policy %s, expected never, on-failure or always", opts.RestartPolicy)
+	}
+
 	// Decode appendArgs (to keep legacy compatibility they're encoded as json for some reason)
 	if opts.appendArgs != "" {
 		args, err := parseAppendArgs(opts.appendArgs)
@@ -138,6 +190,72 @@ func parseAppendArgs(jsonArgs string) (map[string][]string, error) {
 	return args, err
 }
 
+// --port is either a port number, for the first service (or --serve/--reverse-proxy), or SERVICE=PORT
+type portFlag struct {
+	port     *int
+	services map[string]int
+}
+
+func (p *portFlag) String() string {
+	return ""
+}
+
+func (p *portFlag) Set(value string) error {
+	service, port, found := strings.Cut(value, "=")
+	if !found {
+		port = value
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number <= 0 || number > 65535 {
+		return fmt.Errorf("expected a port number or SERVICE=PORT, got %s", value)
+	}
+	if found {
+		p.services[service] = number
+	} else {
+		*p.port = number
+	}
+	return nil
+}
+
+// environment variables set using --env SERVICE:KEY=VALUE, keyed by service then variable name
+type EnvVars map[string]map[string]string
+
+func (e *EnvVars) String() string {
+	return ""
+}
+
+func (e *EnvVars) Set(value string) error {
+	service, key, val, err := parseEnvVar(value)
+	if err != nil {
+		return err
+	}
+	if (*e)[service] == nil {
+		(*e)[service] = map[string]string{}
+	}
+	(*e)[service][key] = val
+	return nil
+}
+
+// splits SERVICE:KEY=VALUE (or just KEY=VALUE) into its parts, the value may contain anything
+func parseEnvVar(value string) (string, string, string, error) {
+	name, val, found := strings.Cut(value, "=")
+	if !found {
+		return "", "", "", fmt.Errorf("expected SERVICE:KEY=VALUE, got %s", value)
+	}
+
+	service := ""
+	key := name
+	if s, k, found := strings.Cut(name, ":"); found {
+		service = s
+		key = k
+	}
+
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", "", fmt.Errorf("invalid environment variable name in %s", value)
+	}
+	return service, key, val, nil
+}
+
 func defaultWorkers() int {
 	defaultValue := 2
 	valueStr := os.Getenv("SM_WORKERS")
@@ -164,6 +282,7 @@ func BuildFlagSet(opts *UserOption) *flag.FlagSet {
 	setUsage(flagset)
 	flagset.StringVar(&opts.appendArgs, "appendArgs", "", "A map of args to append for services you are starting. i.e. '{\"SERVICE_NAME\":[\"-DFoo=Bar\",\"SOMETHING\"],\"SERVICE_TWO\":[\"APPEND_THIS\"]}'")
 	flagset.BoolVar(&opts.AutoComplete, "autocomplete", false, "generates bash completions response (used by bash-completions)")
+	flagset.BoolVar(&opts.AutoPort, "auto-port", false, "starts services on a free port if their usual port is already in use (use with --start)")
 	flagset.BoolVar(&opts.CheckPorts, "checkports", false, "finds services using the same port number")
 	flagset.BoolVar(&opts.Clean, "clean", false, "forces reinstall of service (use with --start)")
 	flagset.StringVar(&opts.CompPreviousWord, "comp-pword", "", "used with --autocomplete by script generated using --generate-autocomplete")
@@ -171,37 +290,61 @@ func BuildFlagSet(opts *UserOption) *flag.FlagSet {
 	flagset.StringVar(&opts.Config, "config", "", "sets an alternate directory for service-manager-config")
 	flagset.StringVar(&opts.Debug, "debug", "", "infomation on why a given `service` may not have started")
 	flagset.BoolVar(&opts.Diagnostic, "diagnostic", false, "a suite of checks to debug issues with service manager")
+	opts.Env = EnvVars{}
+	flagset.Var(&opts.Env, "env", "sets an environment variable for a service, SERVICE:KEY=VALUE or KEY=VALUE for every service, can be repeated (use with --start)")
+	flagset.BoolVar(&opts.Follow, "follow", false, "keeps showing new log lines as they're written (use with --logs)")
+	flagset.BoolVar(&opts.Force, "force", false, "kills services immediately rather than waiting for them to shutdown (use with --stop, --stop-all or --restart)")
+	flagset.StringVar(&opts.FromFile, "from-file", "", "installs a service from a locally built `tarball` rather than artifactory (use with --start)")
+	flagset.StringVar(&opts.FromLock, "from-lock", "", "starts the services in a lock `file` made by --snapshot, at the same versions, ports and args (use with --start)")
 	flagset.BoolVar(&opts.FromSource, "src", false, "run service from source (use with --start)")
+	flagset.StringVar(&opts.Format, "format", "", "sets the output `format` of --status, --list, --search, --ports, --verify, --verify-installs and --offline (plain or json)")
 	flagset.BoolVar(&opts.FormatPlain, "format-plain", false, "list services without formatting")
+	flagset.StringVar(&opts.Grep, "grep", "", "only shows log lines matching a `regex` (use with --logs)")
 	flagset.BoolVar(&opts.GenerateAutoComplete, "generate-autocomplete", false, "generates bash completions script")
+	flagset.IntVar(&opts.KeepVersions, "keep-versions", 0, "keeps the newest n installed versions of each service (use with --clean-cache)")
 	flagset.BoolVar(&opts.Latest, "latest", false, "used in conjunction with -restart to check for latest version of service(s) being restarted")
+	flagset.IntVar(&opts.Lines, "lines", 0, "only shows the last n lines of the logs (use with --logs)")
 	flagset.BoolVar(&opts.List, "list", false, "lists all available services and profiles")
-	flagset.StringVar(&opts.Logs, "logs", "", "shows the stdout logs for a service")
+	flagset.StringVar(&opts.Logs, "logs", "", "shows the stdout logs for one or more services or profiles")
+	flagset.IntVar(&opts.MaxRestarts, "max-restarts", 0, "how many times --supervise will restart a service before giving up, defaults to 5 or maxRestarts in services.json")
 	flagset.BoolVar(&opts.NoPortCheck, "no-port-check", false, "prevents port collision detection (use with --status)")
 	flagset.BoolVar(&opts.NoProgress, "noprogress", false, "prevents download progress being shown (use with --start)")
 	flagset.BoolVar(&opts.NoVpnCheck, "no-vpn-check", defaultVpnCheck(), "disables checking if the vpn is connected")
 	flagset.BoolVar(&opts.Offline, "offline", false, "starts a service in offline mode (use with --start or standalone to list available services)")
-	flagset.IntVar(&opts.Port, "port", -1, "overrides the default port for a service (use with --start)")
+	opts.Port = -1
+	opts.ServicePorts = map[string]int{}
+	flagset.Var(&portFlag{&opts.Port, opts.ServicePorts}, "port", "overrides the default port of the first service, or SERVICE=PORT for any service which can be repeated (use with --start)")
+	flagset.StringVar(&opts.PortRange, "port-range", "", "the `range` of ports --auto-port picks from, defaults to 20000-20999")
 	flagset.BoolVar(&opts.Ports, "ports", false, "shows which ports services use")
+	flagset.IntVar(&opts.Previous, "previous", 0, "shows an older log instead, 1 being the most recent (use with --logs)")
 	flagset.BoolVar(&opts.Prune, "prune", false, "cleans up services with a status of FAIL")
 	flagset.BoolVar(&opts.CleanCache, "clean-cache", false, "deletes all cached services")
 	flagset.StringVar(&opts.Release, "r", "", "sets which `version` to run (use with --start)")
 	flagset.BoolVar(&opts.Restart, "restart", false, "restarts one or more services")
+	flagset.StringVar(&opts.RestartPolicy, "restart-policy", "", "when --supervise should restart a service: never, on-failure or always (use with --start or --supervise)")
 	flagset.BoolVar(&opts.RestartOutdated, "restart-outdated", false, "restarts services running outdated versions")
 	flagset.BoolVar(&opts.ReverseProxy, "reverse-proxy", false, "starts a reverse proxy to all services on port :3000")
+	flagset.BoolVar(&opts.Serve, "serve", false, "runs sm2 as a server providing a local http api on a unix socket in the workspace, and on localhost if --port is given")
 	flagset.StringVar(&opts.Search, "search", "", "searches for services and profiles that match a given `regex`")
+	flagset.BoolVar(&opts.ShowConfig, "show-config", false, "shows the effective settings (from cli flags, env vars and sm2.json) and where they came from")
+	flagset.BoolVar(&opts.Snapshot, "snapshot", false, "prints a lock file of the running services' versions, ports and args, to use with --start --from-lock")
+	flagset.StringVar(&opts.Since, "since", "", "only shows log lines written after a `time` or duration, e.g. 10m or 2024-01-02T15:04:05 (use with --logs)")
 	flagset.BoolVar(&opts.Start, "start", false, "starts one or more service, for a single service use -r to specify version")
 	flagset.BoolVar(&opts.Status, "status", false, "shows which services are running")
 	flagset.BoolVar(&opts.StatusShort, "s", false, "shows which services are running")
 	flagset.BoolVar(&opts.StopAll, "stop-all", false, "stops all services")
+	flagset.IntVar(&opts.StopTimeout, "stop-timeout", 10, "how many seconds to wait for a service to shutdown gracefully before killing it, defaults to 10")
 	flagset.BoolVar(&opts.Stop, "stop", false, "stops one or more services")
+	flagset.BoolVar(&opts.Supervise, "supervise", false, "keeps running and restarts services that stop or fail their healthchecks, based on their restart policy")
 	flagset.BoolVar(&opts.Update, "update", false, "updates sm2 to the latest available version")
 	flagset.BoolVar(&opts.UpdateConfig, "update-config", false, "pulls the latest version of service-manager-config")
 	flagset.BoolVar(&opts.Verbose, "v", false, "enable verbose output")
 	flagset.BoolVar(&opts.Version, "version", false, "show the version of service-manager")
 	flagset.BoolVar(&opts.Verify, "verify", false, "for scripts, checks if a service/profile is running")
+	flagset.BoolVar(&opts.VerifyInstalls, "verify-installs", false, "checks the installed services haven't been changed or corrupted since they were downloaded")
 	flagset.IntVar(&opts.Wait, "wait", 30, "used with --start, waits a specified number of seconds for each service to respond to a healthcheck, defaults to 30")
 	flagset.IntVar(&opts.Workers, "workers", defaultWorkers(), "how many services should be downloaded at the same time (use with --start)")
+	flagset.StringVar(&opts.WriteLog, "write-log", "", "used internally to write the logs of a service to a `file`")
 
 	return flagset
 }
@@ -219,8 +362,8 @@ func setUsage(f *flag.FlagSet) {
 			usage := flag.Usage
 			flagType := strings.TrimSuffix(
 				strings.TrimPrefix(
-					reflect.TypeOf(flag.Value).String(),
-					"*flag."),
+					strings.TrimPrefix(reflect.TypeOf(flag.Value).String(), "*flag."),
+					"*cli."),
 				"Value")
 			if flagType == "bool" {
 				flagType = ""
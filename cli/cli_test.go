@@ -370,3 +370,99 @@ func TestRemovalOfMinusRFlag(t *testing.T) {
 	}
 
 }
+
+func TestFormatOption(t *testing.T) {
+	result, err := Parse([]string{"--status", "--format", "json"})
+	if err != nil {
+		t.Errorf("parse failed %s", err)
+	}
+	if result.Format != "json" || result.FormatPlain {
+		t.Errorf("Expected json format, got %s (plain: %v)", result.Format, result.FormatPlain)
+	}
+
+	result, err = Parse([]string{"--status", "--format", "plain"})
+	if err != nil {
+		t.Errorf("parse failed %s", err)
+	}
+	if !result.FormatPlain {
+		t.Error("Expected --format plain to enable FormatPlain")
+	}
+
+	if _, err = Parse([]string{"--status", "--format", "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestEnvOption(t *testing.T) {
+	args := []string{
+		"--start",
+		"FOO",
+		"BAR",
+		"--env", "FOO:MONGO_URI=mongodb://localhost:27017/foo?a=b",
+		"--env", "FEATURE_X=true",
+		"--env", "FOO:JAVA_OPTS=-Xmx1g",
+	}
+
+	result, err := Parse(args)
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+
+	expected := EnvVars{
+		"":    {"FEATURE_X": "true"},
+		"FOO": {"MONGO_URI": "mongodb://localhost:27017/foo?a=b", "JAVA_OPTS": "-Xmx1g"},
+	}
+	if !reflect.DeepEqual(result.Env, expected) {
+		t.Errorf("expected %v, got %v", expected, result.Env)
+	}
+}
+
+func TestPortOption(t *testing.T) {
+	result, err := Parse([]string{"--start", "FOO", "BAR", "--port", "FOO=9001", "--port", "BAR=9002"})
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+	expected := map[string]int{"FOO": 9001, "BAR": 9002}
+	if !reflect.DeepEqual(result.ServicePorts, expected) || result.Port != -1 {
+		t.Errorf("expected %v and no --port, got %v and %d", expected, result.ServicePorts, result.Port)
+	}
+
+	result, err = Parse([]string{"--start", "FOO", "--port", "9001"})
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+	if result.Port != 9001 || len(result.ServicePorts) != 0 {
+		t.Errorf("expected --port to be 9001, got %d %v", result.Port, result.ServicePorts)
+	}
+
+	port := -1
+	for _, invalid := range []string{"", "abc", "FOO=", "FOO=abc", "0", "70000"} {
+		if err := (&portFlag{&port, map[string]int{}}).Set(invalid); err == nil {
+			t.Errorf("expected %q to be an invalid --port", invalid)
+		}
+	}
+}
+
+func TestParseEnvVar(t *testing.T) {
+	if _, _, _, err := parseEnvVar("FOO:NO_VALUE"); err == nil {
+		t.Errorf("expected an error when there's no =")
+	}
+	if _, _, _, err := parseEnvVar("FOO:=bar"); err == nil {
+		t.Errorf("expected an error when there's no variable name")
+	}
+
+	service, key, value, err := parseEnvVar("FOO:EMPTY=")
+	if err != nil || service != "FOO" || key != "EMPTY" || value != "" {
+		t.Errorf("unexpected result %s %s %s %v", service, key, value, err)
+	}
+}
+
+func TestFromLockNeedsStart(t *testing.T) {
+	if _, err := Parse([]string{"--from-lock", "env.lock.json"}); err == nil {
+		t.Error("Expected an error for --from-lock without --start")
+	}
+	result, err := Parse([]string{"--start", "--from-lock", "env.lock.json"})
+	if err != nil || result.FromLock != "env.lock.json" {
+		t.Errorf("Expected --from-lock env.lock.json, got %q (%v)", result.FromLock, err)
+	}
+}
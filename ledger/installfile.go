@@ -15,7 +15,12 @@ type InstallFile struct {
 	Version  string
 	Path     string
 	Md5Sum   string
+	Sha256   string // of the tarball it was installed from
+	TreeSum  string // sha256 of the installed files, to check they haven't changed since
+	Repo     string // where it was downloaded from
+	Local    bool   `json:",omitempty"` // a local build installed with --from-file, rather than a release
 	Created  time.Time
+	Versions []InstallFile `json:",omitempty"` // every version installed side by side, including this one
 }
 
 func saveInstallFile(installDir string, install InstallFile) error {
@@ -18,9 +18,15 @@ type StateFile struct {
 	Path           string
 	Started        time.Time
 	Pid            int
+	Pgid           int // process group the service was started in, 0 if it wasn't started in its own group
 	Port           int
 	Args           []string
 	HealthcheckUrl string
+	RestartPolicy  string // never, on-failure or always. used by --supervise
+	MaxRestarts    int
+	Restarts       int               // how many times the supervisor has restarted the service
+	LastExit       string            // how the service exited before it was last restarted
+	Env            map[string]string // extra environment variables the service was started with, secrets are redacted
 }
 
 type ProxyState struct {
@@ -1,20 +1,18 @@
 package servicemanager
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"crypto/md5"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
+	"time"
 
 	"sm2/version"
 )
@@ -25,6 +23,7 @@ type MavenMetadata struct {
 	Latest   string   `xml:"versioning>latest"`
 	Release  string   `xml:"versioning>release"`
 	Versions []string `xml:"versioning>versions>version"`
+	Repo     string   `xml:"-"` // the repo the metadata was found in
 }
 
 // checks if a given version is in the MavenMetadata versions.
@@ -50,31 +49,6 @@ func ParseMetadataXml(r io.Reader) (MavenMetadata, error) {
 	return metadata, err
 }
 
-func convertVersionToComparableInts(version string) (int, int, int, error) {
-	parts := strings.Split(version, ".")
-
-	if len(parts) != 3 {
-		return 0, 0, 0, fmt.Errorf("Invalid version format: %s", version)
-	}
-
-	part1, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, 0, 0, err
-	}
-
-	part2, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, 0, err
-	}
-
-	part3, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return 0, 0, 0, err
-	}
-
-	return part1, part2, part3, nil
-}
-
 const (
 	ScalaVersion_3    = "_3"
 	ScalaVersion_2_13 = "_2.13"
@@ -97,7 +71,7 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 	// looks for _%% to always use latest version
 	if latestVersionScalaVersionSuffix.MatchString(s.Artifact) {
 		var result MavenMetadata
-		var thatMajor, thatMinor, thatPatch int
+		var newest semver
 
 		for _, v := range scalaVersions {
 			// tries all Scala versions to find which artifact contains the latest version
@@ -112,18 +86,14 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 				return metadata, nil
 			}
 
-			thisMajor, thisMinor, thisPatch, err := convertVersionToComparableInts(metadata.Latest)
+			latest, err := parseSemver(metadata.Latest)
 
 			if err != nil {
 				return MavenMetadata{}, fmt.Errorf("invalid latest version number: %s", metadata.Latest)
 			}
 
-			if (thisMajor > thatMajor) ||
-				(thisMajor == thatMajor && thisMinor > thatMinor) ||
-				(thisMajor == thatMajor && thisMinor == thatMinor && thisPatch > thatPatch) {
-				thatMajor = thisMajor
-				thatMinor = thisMinor
-				thatPatch = thisPatch
+			if result.Latest == "" || latest.compare(newest) > 0 {
+				newest = latest
 				result = metadata
 			}
 		}
@@ -140,10 +110,76 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 	return metadata, err
 }
 
-// Connects to artifactory and parses maven metadata to get the latest release
+// Looks up the maven metadata for an artifact in each of the repos. The latest release comes from the first repo
+// that has the artifact, but the versions from all of them are included so a version that's only in one of the
+// fallback repos (e.g. snapshots) can still be asked for.
 func (sm *ServiceManager) getLatestVersion(group string, artifact string) (MavenMetadata, error) {
-	// build url
-	url := sm.Config.ArtifactoryRepoUrl + path.Join("/", group, artifact, "maven-metadata.xml")
+	var result MavenMetadata
+	var firstErr error
+	found := false
+
+	for _, repo := range sm.repositories() {
+		metadata, err := sm.getMetadataFromRepo(repo, group, artifact)
+		if err != nil {
+			if firstErr == nil && !errors.Is(err, errNotInRepo) {
+				firstErr = err
+			}
+			continue
+		}
+
+		if !found {
+			result = metadata
+			found = true
+			continue
+		}
+		for _, v := range metadata.Versions {
+			if !result.ContainsVersion(v) {
+				result.Versions = append(result.Versions, v)
+			}
+		}
+	}
+
+	if found {
+		return result, nil
+	}
+	if firstErr != nil {
+		return MavenMetadata{}, firstErr
+	}
+	return MavenMetadata{}, fmt.Errorf("failed to find maven-metadata.xml for %s in %s", artifact, strings.Join(sm.repositories(), ", "))
+}
+
+// Connects to a repo and parses maven metadata to get the latest release
+func (sm *ServiceManager) getMetadataFromRepo(repo string, group string, artifact string) (MavenMetadata, error) {
+	filenames := []string{"maven-metadata.xml"}
+	if isFileRepo(repo) {
+		// what maven writes when installing things locally
+		filenames = append(filenames, "maven-metadata-local.xml")
+	}
+
+	for _, filename := range filenames {
+		// build url
+		url := repo + path.Join("/", group, artifact, filename)
+
+		metadata, err := sm.fetchMetadata(url)
+		if errors.Is(err, errNotInRepo) {
+			continue
+		}
+		metadata.Repo = repo
+		return metadata, err
+	}
+	return MavenMetadata{}, errNotInRepo
+}
+
+// Fetches and parses maven metadata, using the cached copy if its recent enough (see cachedMetadata).
+func (sm *ServiceManager) fetchMetadata(url string) (MavenMetadata, error) {
+	cacheFile := sm.metadataCacheFile(url)
+	cached, isCached := loadCachedMetadata(cacheFile)
+	if isCached && sm.isFresh(cached) {
+		return cached.result()
+	}
+	if sm.Commands.Offline && !isFileRepo(url) {
+		return MavenMetadata{}, fmt.Errorf("no metadata for %s is available offline: %w", url, errNotInRepo)
+	}
 
 	// download metadata
 	ctx, cancel := sm.NewShortContext()
@@ -151,133 +187,217 @@ func (sm *ServiceManager) getLatestVersion(group string, artifact string) (Maven
 	// cleanup the context
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := newArtifactoryRequest(ctx, sm.Config, url)
 	if err != nil {
 		return MavenMetadata{}, err
 	}
-	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := sm.Client.Do(req)
+	// only send it again if its changed
+	if isCached && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if isCached && cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	resp, err := sm.doArtifactoryRequest(req)
 	if err != nil {
+		if isCached {
+			// better out of date than nothing
+			return cached.result()
+		}
 		return MavenMetadata{}, err
 	}
 
 	defer resp.Body.Close()
 
 	// parse metadata
+	if isAuthFailure(resp) {
+		return MavenMetadata{}, artifactoryAuthError(resp, url, sm.Config.ArtifactoryAuth)
+	}
+	if resp.StatusCode == 304 && isCached {
+		cached.Fetched = time.Now()
+		saveCachedMetadata(cacheFile, cached)
+		return cached.result()
+	}
+	if resp.StatusCode == 404 {
+		saveCachedMetadata(cacheFile, cachedMetadata{Url: url, Fetched: time.Now(), NotFound: true})
+		return MavenMetadata{}, fmt.Errorf("failed to find maven-metadata.xml at %s: %w", url, errNotInRepo)
+	}
 	if resp.StatusCode != 200 {
 		return MavenMetadata{}, fmt.Errorf("failed to find maven-metadata.xml at %s", url)
 	}
 
-	return ParseMetadataXml(resp.Body)
+	metadata, err := ParseMetadataXml(resp.Body)
+	if err != nil {
+		return metadata, err
+	}
+	saveCachedMetadata(cacheFile, cachedMetadata{
+		Url:          url,
+		Fetched:      time.Now(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Metadata:     metadata,
+	})
+	return metadata, nil
 }
 
-// downloads a url and attempt to decompress it to a folder
-// assumes the target is a .tgz file
-// this could return the install(service) dir, would remove need to look it up later
-func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, progressWriter *ProgressWriter) (string, error) {
+// how many times a download is attempted before giving up, and how long to wait before retrying (doubling each time)
+var downloadAttempts = 4
+var downloadBackoff = time.Second
+
+// Downloads a .tgz to a temp file, resuming it if the connection drops, checks its checksums and only then
+// extracts it to a folder. If anything goes wrong nothing is left behind in outdir.
+// Returns the service dir and the checksums of the download.
+func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, progressWriter *ProgressWriter) (string, checksums, error) {
+	download, sums, err := sm.downloadVerified(url, outdir, progressWriter)
+	if err != nil {
+		return "", checksums{}, err
+	}
+	defer os.Remove(download)
+
+	serviceDir, err := extractTarGzFile(download, outdir)
+	return serviceDir, sums, err
+}
+
+// Downloads a url to a temp file in dir and checks its checksums. Returns the name of the file, which is up to the
+// caller to remove, and its checksums. Nothing is left behind if it fails.
+func (sm *ServiceManager) downloadVerified(url string, dir string, progressWriter *ProgressWriter) (string, checksums, error) {
 
 	// ensure base dir and logs dir exist
-	if err := os.MkdirAll(outdir, 0755); err != nil {
-		return "", err
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", checksums{}, err
 	}
 
-	// use default timeout. limiting by ctx works if its < client's timeout but not longer...
-	req, err := http.NewRequest("GET", url, nil)
+	download, err := os.CreateTemp(dir, ".download-*.tgz")
 	if err != nil {
-		return "", err
+		return "", checksums{}, err
 	}
+	defer download.Close()
 
-	// overrider header so we can track usage in artifactory
-	req.Header.Set("User-Agent", userAgent)
-
-	resp, err := sm.Client.Do(req)
+	actual, err := sm.downloadAndCheck(url, download, progressWriter)
 	if err != nil {
-		return "", err
+		download.Close()
+		os.Remove(download.Name())
+		return "", checksums{}, err
 	}
-	defer resp.Body.Close()
+	return download.Name(), actual, nil
+}
 
-	//TODO: follow redirect, more status codes etc
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("http GET %s failed with status %s, expected 200", url, resp.Status)
+func (sm *ServiceManager) downloadAndCheck(url string, download *os.File, progressWriter *ProgressWriter) (checksums, error) {
+	expected, err := sm.downloadWithRetries(url, download, progressWriter)
+	if err != nil {
+		return checksums{}, err
+	}
+	if expected.sha256 == "" && expected.sha1 == "" {
+		expected = expected.merge(sm.fetchSidecarChecksums(url))
 	}
 
-	md5Hasher := md5.New()
-	expectedHash, hasMd5 := resp.Header["X-Checksum-Md5"]
-
-	progressWriter.contentLength = int(resp.ContentLength)
-	tee := io.TeeReader(resp.Body, progressWriter) // split off to progress tracker
-	body := io.TeeReader(tee, md5Hasher)           // split off to calculate the checksum
-
-	gz, err := gzip.NewReader(body)
+	// check checksums and fail if they dont match
+	actual, err := hashFile(download)
 	if err != nil {
-		return "", err
+		return checksums{}, err
 	}
-	defer gz.Close()
-
-	// used to determin the serviceDir
-	dirsSeen := map[string]uint8{}
+	return actual, expected.verify(actual)
+}
 
-	tarReader := tar.NewReader(gz)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+// Downloads a url into a file, retrying with an exponential backoff if it fails. Retries carry on from where the
+// last attempt got to using a Range request. Returns the checksums artifactory says the file should have.
+func (sm *ServiceManager) downloadWithRetries(url string, file *os.File, progressWriter *ProgressWriter) (checksums, error) {
+	var err error
+	expected := checksums{}
+	backoff := downloadBackoff
+
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
 		}
 
-		switch header.Typeflag {
+		var sums checksums
+		var retry bool
+		sums, retry, err = sm.downloadPart(url, file, progressWriter)
+		expected = expected.merge(sums)
+		if err == nil {
+			return expected, nil
+		}
+		if !retry {
+			return checksums{}, err
+		}
+	}
+	return checksums{}, fmt.Errorf("gave up downloading %s after %d attempts: %w", url, downloadAttempts, err)
+}
 
-		case tar.TypeDir:
-			// TODO: track dirs created so we can determin where exactly the app is
-			if err := os.MkdirAll(path.Join(outdir, header.Name), 0755); err != nil {
-				log.Fatalf("ExtractTarGz: Mkdir() failed: %s", err.Error())
-			}
+// Downloads whatever's left of a url (i.e. everything after what's already in the file). Returns the checksums from
+// the response and whether its worth trying again if it fails.
+func (sm *ServiceManager) downloadPart(url string, file *os.File, progressWriter *ProgressWriter) (checksums, bool, error) {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return checksums{}, false, err
+	}
 
-		case tar.TypeReg:
-			// create folder if required
-			dir, _ := path.Split(header.Name)
-			if err := os.MkdirAll(path.Join(outdir, dir), 0755); err != nil {
-				log.Fatalf("Failed to created dir %s: %s", dir, err)
-			}
+	// use default timeout. limiting by ctx works if its < client's timeout but not longer...
+	req, err := newArtifactoryRequest(context.Background(), sm.Config, url)
+	if err != nil {
+		return checksums{}, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
-			rootDir := strings.SplitN(path.Clean(dir), "/", 2)[0]
-			dirsSeen[rootDir] = 1
+	resp, err := sm.doArtifactoryRequest(req)
+	if err != nil {
+		return checksums{}, true, err
+	}
+	defer resp.Body.Close()
 
-			// write the file
-			outfile, err := os.Create(path.Join(outdir, header.Name))
-			if err != nil {
-				log.Fatalf("\nfailed to write to file %s\n%s", path.Join(outdir, header.Name), err)
-			}
+	//TODO: follow redirect, more status codes etc
+	if isAuthFailure(resp) {
+		return checksums{}, false, artifactoryAuthError(resp, url, sm.Config.ArtifactoryAuth)
+	}
 
-			_, err = io.Copy(outfile, tarReader)
-			if err != nil {
-				log.Fatal(err)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return checksums{}, false, fmt.Errorf("http GET %s failed with status %s: %w", url, resp.Status, errNotInRepo)
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		// carrying on from where we got to
+	case resp.StatusCode == http.StatusOK:
+		// either its the first attempt, or the server doesn't do ranges and we have to start again
+		if offset > 0 {
+			if err := restartDownload(file, progressWriter); err != nil {
+				return checksums{}, false, err
 			}
-			// fix up the permissions
-			outfile.Chmod(header.FileInfo().Mode())
-			outfile.Close()
+			offset = 0
 		}
-	}
-
-	// check checksum and fail if it doesnt match
-	if hasMd5 {
-		actualHash := fmt.Sprintf("%x", md5Hasher.Sum(nil))
-		if actualHash != expectedHash[0] {
-			return "", fmt.Errorf("md5 did not match, %s != %s", actualHash, expectedHash[0])
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// not the range we asked for, start again from scratch
+		if err := restartDownload(file, progressWriter); err != nil {
+			return checksums{}, false, err
 		}
-		// todo: do we need to return the hash? once validated its not much use tbh!
+		return checksums{}, true, fmt.Errorf("http GET %s returned the wrong range", url)
+	default:
+		return checksums{}, resp.StatusCode >= 500, fmt.Errorf("http GET %s failed with status %s, expected 200", url, resp.Status)
 	}
 
-	// based on the directories we've had to make, figure out which one the service is in
-	// we're assuming theres only one, this could be better
-	var serviceDir string
-
-	delete(dirsSeen, ".")
-	for k := range dirsSeen {
-		// TODO: regex it or something? maybe inc the count every times its seen and go with the largest?
-		//       if we know what the bin dir is (from services.json) we could use that too
-		serviceDir = path.Join(outdir, k)
+	if resp.ContentLength >= 0 {
+		progressWriter.contentLength = int(offset + resp.ContentLength)
 	}
+	written, err := io.Copy(file, io.TeeReader(resp.Body, progressWriter)) // split off to progress tracker
+	if err != nil {
+		return checksumsFromHeaders(resp.Header), true, err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return checksumsFromHeaders(resp.Header), true, fmt.Errorf("http GET %s ended after %d of %d bytes: %w", url, written, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+	return checksumsFromHeaders(resp.Header), false, nil
+}
 
-	return serviceDir, nil
+// throws away a partial download
+func restartDownload(file *os.File, progressWriter *ProgressWriter) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	progressWriter.totalRead = 0
+	return err
 }
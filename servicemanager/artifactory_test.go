@@ -1,6 +1,10 @@
 package servicemanager
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +13,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	. "sm2/testing"
 )
@@ -476,7 +481,7 @@ func TestDownloadAndDecompress(t *testing.T) {
 	}
 
 	// download the mock tgz
-	serviceDir, err := sm.downloadAndDecompress(svr.URL, outdir, &progress)
+	serviceDir, _, err := sm.downloadAndDecompress(svr.URL, outdir, &progress)
 
 	AssertNotErr(t, err)
 
@@ -494,3 +499,136 @@ func TestDownloadAndDecompress(t *testing.T) {
 		t.Errorf("progress tracker read 0 bytes, expected > 0")
 	}
 }
+
+// retry quickly in tests
+func fastRetries(t *testing.T) {
+	attempts, backoff := downloadAttempts, downloadBackoff
+	downloadAttempts, downloadBackoff = 3, time.Millisecond
+	t.Cleanup(func() { downloadAttempts, downloadBackoff = attempts, backoff })
+}
+
+func downloadTo(t *testing.T, url string) (string, string, error) {
+	outdir := t.TempDir()
+	sm := ServiceManager{Client: &http.Client{}}
+	progress := ProgressWriter{renderer: &ProgressRenderer{noProgress: true}}
+	serviceDir, _, err := sm.downloadAndDecompress(url, outdir, &progress)
+	return outdir, serviceDir, err
+}
+
+func assertNothingLeftBehind(t *testing.T, outdir string) {
+	entries, _ := os.ReadDir(outdir)
+	if len(entries) != 0 {
+		t.Errorf("expected a failed download not to leave anything behind, found %d files in %s", len(entries), outdir)
+	}
+}
+
+func TestDownloadResumesAfterConnectionDrops(t *testing.T) {
+	fastRetries(t)
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	requests := 0
+	ranges := []string{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("X-Checksum-Md5", fmt.Sprintf("%x", md5.Sum(tgz)))
+		w.Header().Set("X-Checksum-Sha256", fmt.Sprintf("%x", sha256.Sum256(tgz)))
+		if requests == 1 {
+			// send half of it and hang up
+			w.Header().Set("Content-Length", fmt.Sprint(len(tgz)))
+			w.Write(tgz[:len(tgz)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "playtest-1.0.0.tgz", time.Now(), bytes.NewReader(tgz))
+	}))
+	defer svr.Close()
+
+	_, serviceDir, err := downloadTo(t, svr.URL)
+	AssertNotErr(t, err)
+	AssertFileExists(t, path.Join(serviceDir, "lib", "foo.jar"))
+
+	if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", len(tgz)/2) {
+		t.Errorf("expected the download to be resumed from where it stopped, got requests with ranges %q", ranges)
+	}
+}
+
+func TestDownloadStartsAgainIfRangesArentSupported(t *testing.T) {
+	fastRetries(t)
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	requests := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", fmt.Sprint(len(tgz)))
+		if requests == 1 {
+			w.Write(tgz[:len(tgz)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(tgz)
+	}))
+	defer svr.Close()
+
+	_, serviceDir, err := downloadTo(t, svr.URL)
+	AssertNotErr(t, err)
+	AssertFileExists(t, path.Join(serviceDir, "lib", "foo.jar"))
+}
+
+func TestDownloadGivesUpAfterRetrying(t *testing.T) {
+	fastRetries(t)
+	requests := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(503)
+	}))
+	defer svr.Close()
+
+	outdir, _, err := downloadTo(t, svr.URL)
+	if err == nil || requests != downloadAttempts {
+		t.Errorf("expected the download to fail after %d attempts, got %d (%v)", downloadAttempts, requests, err)
+	}
+	assertNothingLeftBehind(t, outdir)
+}
+
+func TestDownloadDoesntRetryMissingFiles(t *testing.T) {
+	fastRetries(t)
+	requests := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(404)
+	}))
+	defer svr.Close()
+
+	outdir, _, err := downloadTo(t, svr.URL)
+	if !errors.Is(err, errNotInRepo) || requests != 1 {
+		t.Errorf("expected a single attempt to download something that isn't there, got %d (%v)", requests, err)
+	}
+	assertNothingLeftBehind(t, outdir)
+}
+
+func TestDownloadWithBadChecksumLeavesNothingBehind(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Checksum-Md5", "00000000000000000000000000000000")
+		http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+	}))
+	defer svr.Close()
+
+	outdir, _, err := downloadTo(t, svr.URL)
+	if err == nil || !strings.Contains(err.Error(), "md5 did not match") {
+		t.Errorf("expected the checksum not to match, got %v", err)
+	}
+	assertNothingLeftBehind(t, outdir)
+}
+
+func TestCorruptTarballLeavesNothingBehind(t *testing.T) {
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tgz[:len(tgz)-64])
+	}))
+	defer svr.Close()
+
+	outdir, _, err := downloadTo(t, svr.URL)
+	if err == nil {
+		t.Errorf("expected a truncated tarball to fail")
+	}
+	assertNothingLeftBehind(t, outdir)
+}
@@ -0,0 +1,186 @@
+package servicemanager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Credentials for artifactory, only one of them is used: a bearer token, then an api key, then basic auth.
+// They can be set with env vars, in sm2.json or in ~/.netrc, see findArtifactoryAuth.
+type ArtifactoryAuth struct {
+	Token    string `json:"token"`
+	ApiKey   string `json:"apiKey"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	source string // where the credentials came from, for error messages and --show-config
+}
+
+func (a ArtifactoryAuth) isSet() bool {
+	return a.Token != "" || a.ApiKey != "" || (a.Username != "" && a.Password != "")
+}
+
+// describes the credentials without giving them away
+func (a ArtifactoryAuth) describe() string {
+	switch {
+	case a.Token != "":
+		return "bearer token"
+	case a.ApiKey != "":
+		return "api key"
+	case a.Username != "" && a.Password != "":
+		return "basic auth as " + a.Username
+	}
+	return ""
+}
+
+// adds the credentials (if there are any) to a request
+func (a ArtifactoryAuth) apply(req *http.Request) {
+	switch {
+	case a.Token != "":
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case a.ApiKey != "":
+		req.Header.Set("X-JFrog-Art-Api", a.ApiKey)
+	case a.Username != "" && a.Password != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// Works out which credentials to use for artifactory. Env vars take precedence over sm2.json, which takes
+// precedence over ~/.netrc (or $NETRC). Returns an empty ArtifactoryAuth if there aren't any.
+func findArtifactoryAuth(configFile string, userConfig *ArtifactoryAuth, repoUrl string) ArtifactoryAuth {
+	fromEnv := ArtifactoryAuth{
+		Token:    os.Getenv("SM_ARTIFACTORY_TOKEN"),
+		ApiKey:   os.Getenv("SM_ARTIFACTORY_API_KEY"),
+		Username: os.Getenv("SM_ARTIFACTORY_USER"),
+		Password: os.Getenv("SM_ARTIFACTORY_PASSWORD"),
+	}
+	if fromEnv.isSet() {
+		switch {
+		case fromEnv.Token != "":
+			fromEnv.source = "SM_ARTIFACTORY_TOKEN"
+		case fromEnv.ApiKey != "":
+			fromEnv.source = "SM_ARTIFACTORY_API_KEY"
+		default:
+			fromEnv.source = "SM_ARTIFACTORY_USER"
+		}
+		return fromEnv
+	}
+
+	if userConfig != nil && userConfig.isSet() {
+		auth := *userConfig
+		auth.source = configFile
+		return auth
+	}
+
+	netrcFile := os.Getenv("NETRC")
+	if netrcFile == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			netrcFile = path.Join(homeDir, ".netrc")
+		}
+	}
+	if u, err := url.Parse(repoUrl); err == nil && netrcFile != "" {
+		if login, password, ok := netrcCredentials(netrcFile, u.Hostname()); ok {
+			return ArtifactoryAuth{Username: login, Password: password, source: netrcFile}
+		}
+	}
+
+	return ArtifactoryAuth{}
+}
+
+// Looks up the login and password for a host in a .netrc file, falling back to the default entry if there is one.
+// Macros aren't supported, but they're skipped over.
+func netrcCredentials(netrcFile string, host string) (string, string, bool) {
+	file, err := os.Open(netrcFile)
+	if err != nil {
+		return "", "", false
+	}
+	defer file.Close()
+
+	type entry struct{ login, password string }
+	var current *entry
+	var matched, fallback *entry
+
+	scanner := bufio.NewScanner(file)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// macro definitions end with a blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+
+			switch fields[i] {
+			case "machine":
+				current = &entry{}
+				if next() == host && matched == nil {
+					matched = current
+				}
+			case "default":
+				current = &entry{}
+				if fallback == nil {
+					fallback = current
+				}
+			case "login":
+				if current != nil {
+					current.login = next()
+				}
+			case "password":
+				if current != nil {
+					current.password = next()
+				}
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	for _, e := range []*entry{matched, fallback} {
+		if e != nil && e.login != "" && e.password != "" {
+			return e.login, e.password, true
+		}
+	}
+	return "", "", false
+}
+
+// Builds a GET request for artifactory, identifying sm2 (so usage can be tracked) and adding any credentials.
+func newArtifactoryRequest(ctx context.Context, config ServiceManagerConfig, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if sendsCredentials(config, url) {
+		config.ArtifactoryAuth.apply(req)
+	}
+	return req, nil
+}
+
+// a more helpful error than just the status for when artifactory won't let us in
+func artifactoryAuthError(resp *http.Response, url string, auth ArtifactoryAuth) error {
+	if !auth.isSet() {
+		return fmt.Errorf("%s returned %s, artifactory needs credentials, set SM_ARTIFACTORY_TOKEN or add them to sm2.json", url, resp.Status)
+	}
+	return fmt.Errorf("%s returned %s, check the artifactory credentials (%s from %s)", url, resp.Status, auth.describe(), auth.source)
+}
+
+func isAuthFailure(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
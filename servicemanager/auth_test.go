@@ -0,0 +1,169 @@
+package servicemanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a stub artifactory that only responds to requests with the right credentials
+func authenticatedArtifactory(t *testing.T, authorized func(r *http.Request) bool) *httptest.Server {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(401)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "maven-metadata.xml") {
+			fmt.Fprint(w, mavenMetadata)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".tgz") {
+			http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+// checks the credentials are sent with metadata, download and ping requests
+func assertAuthenticatedRequests(t *testing.T, svr *httptest.Server, auth ArtifactoryAuth) {
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{
+			ArtifactoryRepoUrl: svr.URL,
+			ArtifactoryPingUrl: svr.URL + "/api/system/ping",
+			TimeoutShort:       4 * time.Second,
+			ArtifactoryAuth:    auth,
+		},
+	}
+
+	if _, err := sm.getLatestVersion("foo/bar", "foo_2.12"); err != nil {
+		t.Errorf("expected the metadata request to be authenticated with %s: %s", auth.describe(), err)
+	}
+
+	progress := ProgressWriter{renderer: &ProgressRenderer{noProgress: true}}
+	if _, _, err := sm.downloadAndDecompress(svr.URL+"/playtest-1.0.0.tgz", t.TempDir(), &progress); err != nil {
+		t.Errorf("expected the download to be authenticated with %s: %s", auth.describe(), err)
+	}
+
+	if ok, err := checkVpn(sm.Client, sm.Config); !ok {
+		t.Errorf("expected the ping to be authenticated with %s: %s", auth.describe(), err)
+	}
+}
+
+func TestArtifactoryBearerToken(t *testing.T) {
+	svr := authenticatedArtifactory(t, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer abc123"
+	})
+	assertAuthenticatedRequests(t, svr, ArtifactoryAuth{Token: "abc123", ApiKey: "ignored"})
+}
+
+func TestArtifactoryApiKey(t *testing.T) {
+	svr := authenticatedArtifactory(t, func(r *http.Request) bool {
+		return r.Header.Get("X-JFrog-Art-Api") == "key456"
+	})
+	assertAuthenticatedRequests(t, svr, ArtifactoryAuth{ApiKey: "key456"})
+}
+
+func TestArtifactoryBasicAuth(t *testing.T) {
+	svr := authenticatedArtifactory(t, func(r *http.Request) bool {
+		user, password, ok := r.BasicAuth()
+		return ok && user == "jenkins" && password == "s3cret"
+	})
+	assertAuthenticatedRequests(t, svr, ArtifactoryAuth{Username: "jenkins", Password: "s3cret"})
+}
+
+func TestArtifactoryAuthFailure(t *testing.T) {
+	svr := authenticatedArtifactory(t, func(r *http.Request) bool { return false })
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{ArtifactoryRepoUrl: svr.URL},
+	}
+
+	_, err := sm.getLatestVersion("foo/bar", "foo_2.12")
+	if err == nil || !strings.Contains(err.Error(), "needs credentials") {
+		t.Errorf("expected an error saying credentials are needed, got %v", err)
+	}
+
+	sm.Config.ArtifactoryAuth = ArtifactoryAuth{Token: "wrong", source: "/ws/sm2.json"}
+	_, err = sm.getLatestVersion("foo/bar", "foo_2.12")
+	if err == nil || !strings.Contains(err.Error(), "bearer token from /ws/sm2.json") || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("expected an error saying where the credentials came from (but not what they were), got %v", err)
+	}
+}
+
+func TestNewArtifactoryRequestWithoutAuth(t *testing.T) {
+	req, err := newArtifactoryRequest(context.Background(), ServiceManagerConfig{}, "http://localhost/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("X-JFrog-Art-Api") != "" {
+		t.Errorf("expected no credentials to be sent, got %v", req.Header)
+	}
+	if req.Header.Get("User-Agent") != userAgent {
+		t.Errorf("expected the sm2 user agent, got %s", req.Header.Get("User-Agent"))
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	netrc := path.Join(t.TempDir(), ".netrc")
+	os.WriteFile(netrc, []byte(`
+machine github.com login someone password nope
+macdef init
+cd /pub
+machine artefacts.example.com login nope
+
+machine artefacts.example.com
+  login jenkins
+  password s3cret
+default login anonymous password guest
+`), 0600)
+
+	login, password, ok := netrcCredentials(netrc, "artefacts.example.com")
+	if !ok || login != "jenkins" || password != "s3cret" {
+		t.Errorf("expected jenkins/s3cret, got %s/%s", login, password)
+	}
+
+	login, _, ok = netrcCredentials(netrc, "other.example.com")
+	if !ok || login != "anonymous" {
+		t.Errorf("expected the default entry to be used, got %s", login)
+	}
+
+	if _, _, ok := netrcCredentials(path.Join(t.TempDir(), "missing"), "artefacts.example.com"); ok {
+		t.Errorf("expected no credentials from a missing .netrc")
+	}
+}
+
+func TestFindArtifactoryAuthPrecedence(t *testing.T) {
+	netrc := path.Join(t.TempDir(), ".netrc")
+	os.WriteFile(netrc, []byte("machine artefacts.example.com login jenkins password s3cret\n"), 0600)
+	t.Setenv("NETRC", netrc)
+	for _, v := range []string{"SM_ARTIFACTORY_TOKEN", "SM_ARTIFACTORY_API_KEY", "SM_ARTIFACTORY_USER", "SM_ARTIFACTORY_PASSWORD"} {
+		t.Setenv(v, "")
+	}
+	repoUrl := "https://artefacts.example.com/artifactory/releases"
+
+	auth := findArtifactoryAuth("/ws/sm2.json", nil, repoUrl)
+	if auth.Username != "jenkins" || auth.source != netrc {
+		t.Errorf("expected the credentials from .netrc, got %s from %s", auth.describe(), auth.source)
+	}
+
+	auth = findArtifactoryAuth("/ws/sm2.json", &ArtifactoryAuth{ApiKey: "key456"}, repoUrl)
+	if auth.ApiKey != "key456" || auth.source != "/ws/sm2.json" {
+		t.Errorf("expected sm2.json to take precedence over .netrc, got %s from %s", auth.describe(), auth.source)
+	}
+
+	t.Setenv("SM_ARTIFACTORY_TOKEN", "abc123")
+	auth = findArtifactoryAuth("/ws/sm2.json", &ArtifactoryAuth{ApiKey: "key456"}, repoUrl)
+	if auth.Token != "abc123" || auth.source != "SM_ARTIFACTORY_TOKEN" {
+		t.Errorf("expected SM_ARTIFACTORY_TOKEN to take precedence, got %s from %s", auth.describe(), auth.source)
+	}
+}
@@ -72,12 +72,26 @@ func dontComplete(previousWord string) bool {
 		"-comp-pword",
 		"-config",
 		"-debug",
+		"-env",
+		"-format",
+		"-from-file",
+		"-from-lock",
+		"-grep",
+		"-keep-versions",
+		"-lines",
 		"-logs",
+		"-max-restarts",
 		"-port",
+		"-port-range",
 		"-ports",
+		"-previous",
+		"-restart-policy",
 		"-search",
+		"-since",
+		"-stop-timeout",
 		"-wait",
-		"-workers":
+		"-workers",
+		"-write-log":
 		return true
 	}
 	return false
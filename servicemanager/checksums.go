@@ -0,0 +1,258 @@
+package servicemanager
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sm2/ledger"
+)
+
+// the checksums of a download as hex strings, any that aren't known are left empty
+type checksums struct {
+	md5    string
+	sha1   string
+	sha256 string
+}
+
+// the checksums artifactory sends with every download
+func checksumsFromHeaders(header http.Header) checksums {
+	return checksums{
+		md5:    header.Get("X-Checksum-Md5"),
+		sha1:   header.Get("X-Checksum-Sha1"),
+		sha256: header.Get("X-Checksum-Sha256"),
+	}
+}
+
+// fills in any checksums that aren't known from another set
+func (c checksums) merge(other checksums) checksums {
+	if c.md5 == "" {
+		c.md5 = other.md5
+	}
+	if c.sha1 == "" {
+		c.sha1 = other.sha1
+	}
+	if c.sha256 == "" {
+		c.sha256 = other.sha256
+	}
+	return c
+}
+
+// checks every checksum we were given matches the actual one
+func (c checksums) verify(actual checksums) error {
+	for _, check := range []struct{ name, expected, actual string }{
+		{"sha256", c.sha256, actual.sha256},
+		{"sha1", c.sha1, actual.sha1},
+		{"md5", c.md5, actual.md5},
+	} {
+		if check.expected != "" && !strings.EqualFold(check.expected, check.actual) {
+			return fmt.Errorf("%s did not match, %s != %s", check.name, check.actual, check.expected)
+		}
+	}
+	return nil
+}
+
+func hashFile(file io.ReadSeeker) (checksums, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return checksums{}, err
+	}
+	md5Hasher, sha1Hasher, sha256Hasher := md5.New(), sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hasher, sha1Hasher, sha256Hasher), file); err != nil {
+		return checksums{}, err
+	}
+	return checksums{
+		md5:    hex.EncodeToString(md5Hasher.Sum(nil)),
+		sha1:   hex.EncodeToString(sha1Hasher.Sum(nil)),
+		sha256: hex.EncodeToString(sha256Hasher.Sum(nil)),
+	}, nil
+}
+
+// When the download didn't come with a sha256 or sha1 (e.g. it's not from artifactory) look for a .sha256 or .sha1
+// file alongside it, as maven repos usually have them.
+func (sm *ServiceManager) fetchSidecarChecksums(url string) checksums {
+	if sum := sm.fetchSidecarChecksum(url+".sha256", sha256.Size); sum != "" {
+		return checksums{sha256: sum}
+	}
+	return checksums{sha1: sm.fetchSidecarChecksum(url+".sha1", sha1.Size)}
+}
+
+// returns the checksum from a sidecar file, or "" if there isn't one (or its not a checksum)
+func (sm *ServiceManager) fetchSidecarChecksum(url string, size int) string {
+	ctx, cancel := sm.NewShortContext()
+	defer cancel()
+
+	req, err := newArtifactoryRequest(ctx, sm.Config, url)
+	if err != nil {
+		return ""
+	}
+	resp, err := sm.doArtifactoryRequest(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return ""
+	}
+	// usually just the checksum, but sometimes followed by the filename like sha256sum's output
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return ""
+	}
+	if decoded, err := hex.DecodeString(fields[0]); err != nil || len(decoded) != size {
+		return ""
+	}
+	return fields[0]
+}
+
+// A sha256 of everything in an install, so it can be checked later by --verify-installs. The logs and RUNNING_PID
+// are left out since they change whenever the service runs.
+func treeSum(serviceDir string) (string, error) {
+	hasher := sha256.New()
+	err := filepath.WalkDir(serviceDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(serviceDir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == "logs" && entry.IsDir():
+			return filepath.SkipDir
+		case rel == "RUNNING_PID" || entry.IsDir():
+			return nil
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "%s -> %s\n", rel, target)
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fileHasher := sha256.New()
+		if _, err := io.Copy(fileHasher, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%s %o %x\n", rel, info.Mode().Perm(), fileHasher.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+const (
+	InstallOk       = "OK"
+	InstallModified = "MODIFIED"
+	InstallMissing  = "MISSING"
+	InstallUnknown  = "UNKNOWN" // installed from source or by an older sm2, so there's nothing to check it against
+)
+
+type installCheck struct {
+	install ledger.InstallFile
+	status  string
+}
+
+// re-hashes every installed version of every service and compares it to the checksum taken when it was installed
+func (sm *ServiceManager) checkInstalls() ([]installCheck, error) {
+	files, err := os.ReadDir(sm.Config.TmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []installCheck{}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		installFile, err := sm.Ledger.LoadInstallFile(path.Join(sm.Config.TmpDir, file.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, install := range installedVersions(installFile) {
+			result := installCheck{install: install, status: InstallUnknown}
+			if !Exists(install.Path) {
+				result.status = InstallMissing
+			} else if install.TreeSum != "" {
+				sum, err := treeSum(install.Path)
+				if err != nil || sum != install.TreeSum {
+					result.status = InstallModified
+				} else {
+					result.status = InstallOk
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// checks the installed services haven't been changed or corrupted since they were installed
+// returns false if any of them have
+func (sm *ServiceManager) VerifyInstalls() bool {
+	results, err := sm.checkInstalls()
+	if err != nil {
+		fmt.Printf("failed to read the workspace dir, %s\n", err)
+		return false
+	}
+
+	ok := true
+	for _, r := range results {
+		if r.status == InstallModified || r.status == InstallMissing {
+			ok = false
+		}
+	}
+
+	if sm.formatJson() {
+		checks := []jsonInstallCheck{}
+		for _, r := range results {
+			checks = append(checks, jsonInstallCheck{
+				Service: r.install.Service,
+				Version: r.install.Version,
+				Path:    r.install.Path,
+				Sha256:  r.install.Sha256,
+				Status:  r.status,
+			})
+		}
+		printJson(checks, os.Stdout)
+		return ok
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No services are installed.")
+		return ok
+	}
+
+	for _, r := range results {
+		fmt.Printf(" %-25s %-15s %s\n", r.install.Service, r.install.Version, r.status)
+	}
+	if !ok {
+		fmt.Println("Some services have changed since they were installed, reinstall them with sm2 --start SERVICE_NAME --clean")
+	}
+	return ok
+}
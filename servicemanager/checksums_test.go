@@ -0,0 +1,165 @@
+package servicemanager
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+)
+
+func TestChecksumsVerify(t *testing.T) {
+	actual := checksums{md5: "aa", sha1: "bb", sha256: "cc"}
+
+	if err := (checksums{}).verify(actual); err != nil {
+		t.Errorf("expected nothing to check to be ok, got %s", err)
+	}
+	if err := (checksums{sha256: "CC", md5: "aa"}).verify(actual); err != nil {
+		t.Errorf("expected matching checksums to be ok, got %s", err)
+	}
+	if err := (checksums{sha1: "xx"}).verify(actual); err == nil || !strings.Contains(err.Error(), "sha1 did not match") {
+		t.Errorf("expected the sha1 not to match, got %v", err)
+	}
+}
+
+// serves the test tarball, with whichever checksum headers and sidecar files are given
+func checksumServer(t *testing.T, headers map[string]string, sidecars map[string]string) *httptest.Server {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for ext, body := range sidecars {
+			if strings.HasSuffix(r.URL.Path, ext) {
+				fmt.Fprint(w, body)
+				return
+			}
+		}
+		if !strings.HasSuffix(r.URL.Path, ".tgz") {
+			w.WriteHeader(404)
+			return
+		}
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+func TestDownloadVerifiesSha256Header(t *testing.T) {
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	expected := fmt.Sprintf("%x", sha256.Sum256(tgz))
+
+	svr := checksumServer(t, map[string]string{"X-Checksum-Sha256": expected}, nil)
+	sm := ServiceManager{Client: &http.Client{}}
+	progress := ProgressWriter{renderer: &ProgressRenderer{noProgress: true}}
+	_, sums, err := sm.downloadAndDecompress(svr.URL+"/playtest-1.0.0.tgz", t.TempDir(), &progress)
+	if err != nil || sums.sha256 != expected {
+		t.Errorf("expected the download to have sha256 %s, got %s (%v)", expected, sums.sha256, err)
+	}
+
+	svr = checksumServer(t, map[string]string{"X-Checksum-Sha256": strings.Repeat("0", 64)}, nil)
+	outdir, _, err := downloadTo(t, svr.URL+"/playtest-1.0.0.tgz")
+	if err == nil || !strings.Contains(err.Error(), "sha256 did not match") {
+		t.Errorf("expected the sha256 not to match, got %v", err)
+	}
+	assertNothingLeftBehind(t, outdir)
+}
+
+func TestDownloadVerifiesSidecarChecksums(t *testing.T) {
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+
+	svr := checksumServer(t, nil, map[string]string{".sha256": fmt.Sprintf("%x  playtest-1.0.0.tgz\n", sha256.Sum256(tgz))})
+	if _, _, err := downloadTo(t, svr.URL+"/playtest-1.0.0.tgz"); err != nil {
+		t.Errorf("expected the .sha256 file to match, got %s", err)
+	}
+
+	svr = checksumServer(t, nil, map[string]string{".sha1": fmt.Sprintf("%x", sha1.Sum(append(tgz, 'x')))})
+	outdir, _, err := downloadTo(t, svr.URL+"/playtest-1.0.0.tgz")
+	if err == nil || !strings.Contains(err.Error(), "sha1 did not match") {
+		t.Errorf("expected the .sha1 file not to match, got %v", err)
+	}
+	assertNothingLeftBehind(t, outdir)
+
+	// a repo that returns something that isn't a checksum for the sidecar files
+	svr = checksumServer(t, nil, map[string]string{".sha256": "<html>not found</html>", ".sha1": ""})
+	if _, _, err := downloadTo(t, svr.URL+"/playtest-1.0.0.tgz"); err != nil {
+		t.Errorf("expected sidecar files that aren't checksums to be ignored, got %s", err)
+	}
+}
+
+func TestTreeSumIgnoresLogs(t *testing.T) {
+	_, serviceDir, err := downloadTo(t, checksumServer(t, nil, nil).URL+"/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := treeSum(serviceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(path.Join(serviceDir, "logs"), 0755)
+	os.WriteFile(path.Join(serviceDir, "logs", "stdout.log"), []byte("started"), 0644)
+	os.WriteFile(path.Join(serviceDir, "RUNNING_PID"), []byte("123"), 0644)
+	if after, _ := treeSum(serviceDir); after != before {
+		t.Errorf("expected logs and RUNNING_PID not to change the tree sum")
+	}
+
+	os.WriteFile(path.Join(serviceDir, "lib", "foo.jar"), []byte("tampered"), 0644)
+	if after, _ := treeSum(serviceDir); after == before {
+		t.Errorf("expected a changed jar to change the tree sum")
+	}
+}
+
+func TestCheckInstalls(t *testing.T) {
+	svr := checksumServer(t, nil, nil)
+	sm := repoServiceManager(t, svr.URL)
+	sm.Config.TmpDir = t.TempDir()
+
+	install := func(service string) ledger.InstallFile {
+		installFile, err := sm.installService(path.Join(sm.Config.TmpDir, strings.ToLower(service)), service, "foo.bar", "playtest", "1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return installFile
+	}
+	install("OK_SERVICE")
+	tampered := install("TAMPERED_SERVICE")
+	missing := install("MISSING_SERVICE")
+
+	os.WriteFile(path.Join(tampered.Path, "bin", "playtest"), []byte("#!/bin/sh\necho pwned"), 0755)
+	os.RemoveAll(missing.Path)
+
+	// installed by an older version of sm2
+	oldDir := path.Join(sm.Config.TmpDir, "old_service")
+	os.MkdirAll(oldDir, 0755)
+	sm.Ledger.SaveInstallFile(oldDir, ledger.InstallFile{Service: "OLD_SERVICE", Path: oldDir})
+
+	results, err := sm.checkInstalls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]string{}
+	for _, r := range results {
+		statuses[r.install.Service] = r.status
+	}
+	expected := map[string]string{
+		"OK_SERVICE":       InstallOk,
+		"TAMPERED_SERVICE": InstallModified,
+		"MISSING_SERVICE":  InstallMissing,
+		"OLD_SERVICE":      InstallUnknown,
+	}
+	for service, status := range expected {
+		if statuses[service] != status {
+			t.Errorf("expected %s to be %s, got %s", service, status, statuses[service])
+		}
+	}
+
+	if sm.VerifyInstalls() {
+		t.Errorf("expected --verify-installs to fail when installs have changed")
+	}
+}
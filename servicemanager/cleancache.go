@@ -7,20 +7,37 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+
+	"sm2/ledger"
 )
 
-// CleanCache removes all cached service installations from the workspace.
+// CleanCache removes all cached service installations and downloaded tarballs from the workspace.
 // It skips services that are currently running and prompts for confirmation unless
 // verbose mode is enabled or the user confirms the operation.
+// With --keep-versions only the older installed versions of each service are removed.
 func (sm *ServiceManager) CleanCache() error {
 
+	if sm.Commands.KeepVersions > 0 {
+		return sm.pruneOldVersions(sm.Commands.KeepVersions)
+	}
+
 	// Scan the installation directory
 	files, err := os.ReadDir(sm.Config.TmpDir)
 	if err != nil {
 		return fmt.Errorf("failed to read installation directory: %s", err)
 	}
 
-	if len(files) == 0 {
+	// and the tarballs we've downloaded
+	tarballs, err := sm.tarballCache().entries()
+	if err != nil {
+		return fmt.Errorf("failed to read tarball cache: %s", err)
+	}
+	var tarballSize int64
+	for _, tarball := range tarballs {
+		tarballSize += tarball.size
+	}
+
+	if len(files) == 0 && len(tarballs) == 0 {
 		fmt.Println("No cached services found.")
 		return nil
 	}
@@ -77,7 +94,7 @@ func (sm *ServiceManager) CleanCache() error {
 		})
 	}
 
-	if len(services) == 0 {
+	if len(services) == 0 && len(tarballs) == 0 {
 		fmt.Println("No cached services found.")
 		return nil
 	}
@@ -97,9 +114,13 @@ func (sm *ServiceManager) CleanCache() error {
 	for i, svc := range services {
 		tbl.FitRow(svc.name, svc.version, formattedSizes[i])
 	}
+	tarballCount := fmt.Sprintf("%d file(s)", len(tarballs))
+	if len(tarballs) > 0 {
+		tbl.FitRow("tarballs", tarballCount, formatSize(tarballSize))
+	}
 
 	// Display what will be pruned
-	fmt.Printf("\nFound %d cached service(s):\n", len(services))
+	fmt.Printf("\nFound %d cached service(s) and %d downloaded tarball(s):\n", len(services), len(tarballs))
 	tbl.Separator()
 
 	runningCount := 0
@@ -116,31 +137,28 @@ func (sm *ServiceManager) CleanCache() error {
 			[]string{"", "", "", color},
 		)
 	}
+	if len(tarballs) > 0 {
+		tbl.PrintColoredRow(
+			[]string{"tarballs", tarballCount, formatSize(tarballSize), "[ready to prune]"},
+			[]string{"", "", "", ColorGreen},
+		)
+	}
 
 	tbl.Separator()
-	fmt.Printf("Total disk space: %s\n", formatSize(totalSize))
+	fmt.Printf("Total disk space: %s\n", formatSize(totalSize+tarballSize))
 
 	if runningCount > 0 {
 		fmt.Printf("\nNote: %d service(s) are currently running and will be skipped.\n", runningCount)
 	}
 
-	if runningCount == len(services) {
+	if runningCount == len(services) && len(tarballs) == 0 {
 		fmt.Println("\nAll cached services are currently running. Nothing to delete.")
 		return nil
 	}
 
 	// Ask for confirmation
-	fmt.Print("\nAre you sure you want to delete all cached services? (y/n): ")
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("failed to read input: %s", err)
-	}
-
-	response = strings.TrimSpace(strings.ToLower(response))
-	if response != "y" && response != "yes" {
-		fmt.Println("Operation cancelled.")
-		return nil
+	if ok, err := sm.confirmCleanCache("Are you sure you want to delete all cached services?"); !ok {
+		return err
 	}
 
 	// Perform the pruning
@@ -172,9 +190,23 @@ func (sm *ServiceManager) CleanCache() error {
 		}
 	}
 
+	// only the tarballs, the rest of the cache (e.g. maven metadata) is left alone
+	deletedTarballs := 0
+	cache := sm.tarballCache()
+	for _, tarball := range tarballs {
+		sm.PrintVerbose("Deleting %s...\n", tarball.path)
+		if err := cache.remove(tarball.path); err != nil {
+			fmt.Printf("Warning: Failed to delete %s: %s\n", tarball.path, err)
+		} else {
+			deletedTarballs++
+			freedSpace += tarball.size
+		}
+	}
+
 	// Print summary
 	fmt.Println("\nPrune complete:")
 	fmt.Printf("  • Deleted: %d service(s)\n", deletedCount)
+	fmt.Printf("  • Deleted: %d downloaded tarball(s)\n", deletedTarballs)
 	fmt.Printf("  • Skipped: %d service(s)\n", skippedCount)
 	fmt.Printf("  • Freed: %s\n", formatSize(freedSpace))
 
@@ -185,6 +217,127 @@ func (sm *ServiceManager) CleanCache() error {
 	return nil
 }
 
+// asks the user to confirm before anything is deleted, unless verbose mode is enabled
+func (sm *ServiceManager) confirmCleanCache(question string) (bool, error) {
+	if sm.Commands.Verbose {
+		return true, nil
+	}
+	fmt.Printf("\n%s (y/n): ", question)
+	reader := bufio.NewReader(os.Stdin)
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read input: %s", err)
+	}
+
+	response = strings.TrimSpace(strings.ToLower(response))
+	if response != "y" && response != "yes" {
+		fmt.Println("Operation cancelled.")
+		return false, nil
+	}
+	return true, nil
+}
+
+// pruneOldVersions removes all but the newest n installed versions of each service.
+// The version a running service is using is never removed.
+func (sm *ServiceManager) pruneOldVersions(keep int) error {
+
+	files, err := os.ReadDir(sm.Config.TmpDir)
+	if err != nil {
+		return fmt.Errorf("failed to read installation directory: %s", err)
+	}
+
+	type oldVersion struct {
+		installDir string
+		install    ledger.InstallFile
+		size       int64
+	}
+
+	var versions []oldVersion
+	var totalSize int64
+
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		installDir := path.Join(sm.Config.TmpDir, file.Name())
+		installFile, err := sm.Ledger.LoadInstallFile(installDir)
+		if err != nil {
+			continue
+		}
+
+		inUse := ""
+		if state, err := sm.Ledger.LoadStateFile(installDir); err == nil {
+			if _, exists := sm.Platform.PidLookup()[state.Pid]; exists {
+				inUse = state.Path
+			}
+		}
+
+		for _, v := range versionsToPrune(installFile, keep, inUse) {
+			size := calculateDirSize(v.Path)
+			totalSize += size
+			versions = append(versions, oldVersion{installDir: installDir, install: v, size: size})
+		}
+	}
+
+	if len(versions) == 0 {
+		fmt.Printf("No services have more than %d version(s) installed.\n", keep)
+		return nil
+	}
+
+	tbl := NewTable("  ", "   ")
+	tbl.SetMinWidth(0, 10, false) // name
+	tbl.SetMinWidth(1, 7, false)  // version
+	tbl.SetMinWidth(2, 4, true)   // size (right-aligned)
+	for _, v := range versions {
+		tbl.FitRow(v.install.Service, v.install.Version, formatSize(v.size))
+	}
+
+	fmt.Printf("\nFound %d version(s) older than the newest %d of each service:\n", len(versions), keep)
+	tbl.Separator()
+	for _, v := range versions {
+		tbl.PrintColoredRow(
+			[]string{v.install.Service, v.install.Version, formatSize(v.size)},
+			[]string{"", "", ""},
+		)
+	}
+	tbl.Separator()
+	fmt.Printf("Total disk space: %s\n", formatSize(totalSize))
+
+	if ok, err := sm.confirmCleanCache("Are you sure you want to delete these versions?"); !ok {
+		return err
+	}
+
+	fmt.Println("\nPruning old versions...")
+
+	deleted := map[string][]ledger.InstallFile{}
+	deletedCount := 0
+	freedSpace := int64(0)
+	for _, v := range versions {
+		sm.PrintVerbose("Deleting %s %s...\n", v.install.Service, v.install.Version)
+		if err := removeVersion(v.installDir, v.install); err != nil {
+			fmt.Printf("Warning: Failed to delete %s %s: %s\n", v.install.Service, v.install.Version, err)
+			continue
+		}
+		deleted[v.installDir] = append(deleted[v.installDir], v.install)
+		deletedCount++
+		freedSpace += v.size
+		if !sm.Commands.NoProgress {
+			fmt.Printf("  ✓ Deleted %s (%s)\n", v.install.Service, v.install.Version)
+		}
+	}
+
+	for installDir, removed := range deleted {
+		if err := sm.forgetVersions(installDir, removed); err != nil {
+			fmt.Printf("Warning: Failed to update %s: %s\n", installDir, err)
+		}
+	}
+
+	fmt.Println("\nPrune complete:")
+	fmt.Printf("  • Deleted: %d version(s)\n", deletedCount)
+	fmt.Printf("  • Freed: %s\n", formatSize(freedSpace))
+	return nil
+}
+
 // calculateDirSize recursively calculates the size of a directory in bytes
 func calculateDirSize(dirPath string) int64 {
 	var size int64
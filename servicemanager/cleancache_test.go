@@ -218,3 +218,35 @@ func TestCalculateDirSize(t *testing.T) {
 		t.Errorf("calculateDirSize() = %d, expected %d", size, expectedSize)
 	}
 }
+
+func TestCleanCache_OnlyDeletesTarballs(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := path.Join(tmpDir, "cache")
+
+	tarball := path.Join(cacheDir, "0123456789abcdef", "uk", "gov", "foo", "1.0.0", "foo-1.0.0.tgz")
+	os.MkdirAll(path.Dir(tarball), 0755)
+	os.WriteFile(tarball, []byte("tarball"), 0644)
+	os.WriteFile(tarball+".json", []byte("{}"), 0644)
+
+	metadata := path.Join(cacheDir, "metadata", "abc.json")
+	os.MkdirAll(path.Dir(metadata), 0755)
+	os.WriteFile(metadata, []byte("{}"), 0644)
+
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: path.Join(tmpDir, "install"), CacheDir: cacheDir},
+		Commands: cli.UserOption{Verbose: true},
+		Ledger:   ledger.NewLedger(),
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{} }},
+	}
+	os.MkdirAll(sm.Config.TmpDir, 0755)
+
+	if err := sm.CleanCache(); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(tarball) || Exists(path.Join(cacheDir, "0123456789abcdef")) {
+		t.Errorf("expected the tarball and its dirs to be deleted")
+	}
+	if !Exists(metadata) {
+		t.Errorf("expected the metadata cache to be kept")
+	}
+}
@@ -5,26 +5,31 @@ import (
 	"os"
 	"regexp"
 	"sm2/version"
+	"strconv"
 )
 
 type ServiceAndVersion struct {
 	service      string
 	version      string
 	scalaVersion string
+	port         int  // 0 if it should run on its default port
+	fromSource   bool // run from source rather than a release, for services that were in a lock file (see --from-lock)
 }
 
-var serviceAndVersionRegex *regexp.Regexp = regexp.MustCompile(`(.*?)(_(2\.\d{2}|3))?(:(.*))?$`)
+var serviceAndVersionRegex *regexp.Regexp = regexp.MustCompile(`(.*?)(_(2\.\d{2}|3))?(:(.*?))?(@(\d+))?$`)
 
+// parses SERVICE[_SCALA][:VERSION][@PORT], e.g. FOO_2.13:1.2.3@9001
 func parseServiceAndVersion(serviceDescriptor string) ServiceAndVersion {
 	matches := serviceAndVersionRegex.FindStringSubmatch(serviceDescriptor)
 
 	if matches == nil {
-		return ServiceAndVersion{serviceDescriptor, "", ""}
+		return ServiceAndVersion{serviceDescriptor, "", "", 0, false}
 	} else {
 		service := matches[1]
 		scalaVersion := matches[3]
 		version := matches[5]
-		return ServiceAndVersion{service, version, scalaVersion}
+		port, _ := strconv.Atoi(matches[7])
+		return ServiceAndVersion{service, version, scalaVersion, port, false}
 	}
 }
 
@@ -45,9 +50,22 @@ func (sm *ServiceManager) Run() {
 		}
 	}
 
-	if sm.Commands.Status || sm.Commands.StatusShort {
+	// when an sm2 server is running let it do the work rather than doing it ourselves
+	if !sm.Commands.Serve {
+		if client := sm.serverClient(); client != nil && sm.runOnServer(client) {
+			return
+		}
+	}
+
+	if sm.Commands.Serve {
+		// runs sm2 as a long-running server with a local http api
+		sm.StartServer()
+	} else if sm.Commands.Status || sm.Commands.StatusShort {
 		// prints table of running services
 		sm.PrintStatus()
+	} else if sm.Commands.Snapshot {
+		// prints a lock file of what's running, for --start --from-lock
+		err = sm.Snapshot()
 	} else if sm.Commands.Prune {
 		// cleans up state files for services with a status of FAIL
 		sm.cleanupFailedServices()
@@ -55,9 +73,23 @@ func (sm *ServiceManager) Run() {
 		// deletes all cached service versions
 		err = sm.CleanCache()
 	} else if sm.Commands.Start {
-		// starts service(s) or profile(s)
+		// starts service(s) or profile(s), or everything in a lock file
 		services := sm.requestedServicesAndProfiles()
-		sm.asyncStart(services)
+		if sm.Commands.FromLock != "" {
+			services, err = sm.applyLockFile()
+		}
+		if err == nil {
+			err = sm.validateFromFile(services)
+		}
+		if err == nil {
+			sm.asyncStart(services)
+			if sm.Commands.Supervise {
+				sm.Supervise(services)
+			}
+		}
+	} else if sm.Commands.Supervise {
+		// restarts services that stop or become unhealthy, according to their restart policy
+		sm.Supervise(sm.requestedServicesAndProfiles())
 	} else if sm.Commands.Stop {
 		// stops a specific service or profile
 		services := sm.requestedServicesAndProfiles()
@@ -67,28 +99,10 @@ func (sm *ServiceManager) Run() {
 	} else if sm.Commands.StopAll {
 		// stops all managed services
 		sm.StopAll()
-	} else if sm.Commands.Restart && sm.Commands.Latest {
-		// restarts service(s) or profile(s) by doing an explicit stop and start to pick up latest versions
-		services := sm.requestedServicesAndProfiles()
-
-		for _, s := range services {
-			sm.StopService(s.service)
-		}
-
-		sm.asyncStart(services)
 	} else if sm.Commands.Restart {
 		// restarts service(s) or profile(s)
 		services := sm.requestedServicesAndProfiles()
-		failed := []ServiceAndVersion{}
-		for _, s := range services {
-			if err := sm.Restart(s); err != nil {
-				failed = append(failed, s)
-			}
-		}
-		// try and start the failed services (which are probably just not running)
-		if len(failed) > 0 {
-			sm.asyncStart(failed)
-		}
+		sm.restartServices(services)
 	} else if sm.Commands.RestartOutdated {
 		// restarts services running outdated versions
 		sm.RestartOutdated()
@@ -104,8 +118,8 @@ func (sm *ServiceManager) Run() {
 		// alias for search everything
 		sm.ListServices(".", sm.Commands.FormatPlain)
 	} else if sm.Commands.Logs != "" {
-		// dumps stdout.log to stdout
-		sm.PrintLogsForService(sm.Commands.Logs)
+		// dumps stdout.log to stdout, for one or more services
+		sm.PrintLogs()
 	} else if sm.Commands.ReverseProxy {
 		// starts a reverse proxy for frontend services
 		sm.StartProxy()
@@ -127,6 +141,14 @@ func (sm *ServiceManager) Run() {
 		if !ok {
 			os.Exit(13)
 		}
+	} else if sm.Commands.VerifyInstalls {
+		// checks nothing has changed in the installed services
+		if !sm.VerifyInstalls() {
+			os.Exit(1)
+		}
+	} else if sm.Commands.ShowConfig {
+		// prints the effective settings, e.g. from sm2.json
+		sm.ShowConfig(os.Stdout)
 	} else if sm.Commands.Update {
 		err = update(sm.Config.TmpDir)
 	} else if sm.Commands.GenerateAutoComplete {
@@ -146,6 +168,30 @@ func (sm *ServiceManager) Run() {
 
 }
 
+// restarts service(s) or profile(s). With --latest they're stopped and started again to pick up the latest
+// versions, otherwise they're restarted using their previous configuration. Anything that can't be restarted
+// (probably because its not running) is started instead.
+func (sm *ServiceManager) restartServices(services []ServiceAndVersion) map[string]error {
+	if sm.Commands.Latest {
+		for _, s := range services {
+			sm.StopService(s.service)
+		}
+		return sm.asyncStart(services)
+	}
+
+	failed := []ServiceAndVersion{}
+	for _, s := range services {
+		if err := sm.Restart(s); err != nil {
+			failed = append(failed, s)
+		}
+	}
+	// try and start the failed services (which are probably just not running)
+	if len(failed) > 0 {
+		return sm.asyncStart(failed)
+	}
+	return map[string]error{}
+}
+
 // get a list of service names to use in the command.
 // profiles are expanded out etc...
 func (sm *ServiceManager) requestedServicesAndProfiles() []ServiceAndVersion {
@@ -154,14 +200,22 @@ func (sm *ServiceManager) requestedServicesAndProfiles() []ServiceAndVersion {
 
 	for i, s := range sm.Commands.ExtraServices {
 		if profileServices, ok := sm.Profiles[s]; ok {
-			for _, ps := range profileServices {
-				output = append(output, ServiceAndVersion{ps, "", ""})
+			for _, entry := range profileServices {
+				ps, scalaVersion, version := parseProfileEntry(entry)
+				output = append(output, ServiceAndVersion{ps, version, scalaVersion, sm.Commands.ServicePorts[ps], false})
 			}
 		} else {
 			serviceAndVersion := parseServiceAndVersion(s)
 			if i == 0 && sm.Commands.Release != "" {
 				serviceAndVersion.version = sm.Commands.Release
 			}
+			// SERVICE@PORT takes precedence over --port SERVICE=PORT, which takes precedence over --port PORT
+			if serviceAndVersion.port == 0 {
+				serviceAndVersion.port = sm.Commands.ServicePorts[serviceAndVersion.service]
+			}
+			if i == 0 && serviceAndVersion.port == 0 && sm.Commands.Port > 0 {
+				serviceAndVersion.port = sm.Commands.Port
+			}
 			output = append(output, serviceAndVersion)
 		}
 	}
@@ -1,74 +1,140 @@
 package servicemanager
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+
+	"sm2/cli"
 )
 
 func TestParseServiceAndVersion(t *testing.T) {
 
 	serviceAndVersion := parseServiceAndVersion("CATALOGUE_FRONTEND")
-	expectedServiceAndVersion := ServiceAndVersion{"CATALOGUE_FRONTEND", "", ""}
+	expectedServiceAndVersion := ServiceAndVersion{"CATALOGUE_FRONTEND", "", "", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.11")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.11"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.11", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.12")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.12"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.12", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.13")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.13"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "2.13", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_3")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "3"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "3", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND:0.499.0")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", ""}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.11:0.499.0")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.11"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.11", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.12:0.499.0")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.12"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.12", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.13:0.499.0")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.13"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.13", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_3:0.499.0")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "3"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "3", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
 
 	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_3:10.11")
-	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "10.11", "3"}
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "10.11", "3", 0, false}
 	if serviceAndVersion != expectedServiceAndVersion {
 		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
 	}
+
+	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND@9001")
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "", "", 9001, false}
+	if serviceAndVersion != expectedServiceAndVersion {
+		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
+	}
+
+	serviceAndVersion = parseServiceAndVersion("CATALOGUE_FRONTEND_2.13:0.499.0@9001")
+	expectedServiceAndVersion = ServiceAndVersion{"CATALOGUE_FRONTEND", "0.499.0", "2.13", 9001, false}
+	if serviceAndVersion != expectedServiceAndVersion {
+		t.Errorf("Parsed: %#v did not match expected: %#v", serviceAndVersion, expectedServiceAndVersion)
+	}
+}
+
+func TestRequestedServicePorts(t *testing.T) {
+	sm := ServiceManager{
+		Profiles: map[string][]string{"PROFILE": {"BAR", "BAZ"}},
+		Commands: cli.UserOption{
+			ExtraServices: []string{"FOO", "QUX@9004", "PROFILE"},
+			Port:          9001,
+			ServicePorts:  map[string]int{"BAZ": 9003, "QUX": 9005},
+		},
+	}
+
+	expected := []ServiceAndVersion{{"FOO", "", "", 9001, false}, {"QUX", "", "", 9004, false}, {"BAR", "", "", 0, false}, {"BAZ", "", "", 9003, false}}
+	if services := sm.requestedServicesAndProfiles(); !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+}
+
+func TestRequestedServicesFromProfileWithVersions(t *testing.T) {
+	sm := ServiceManager{
+		Profiles: map[string][]string{"PROFILE": {"FOO:1.2.3", "BAR:^2.3", "BAZ", "QUX_2.13:^1.0", "QUUX_3"}},
+		Commands: cli.UserOption{
+			ExtraServices: []string{"PROFILE"},
+			ServicePorts:  map[string]int{"BAR": 9003},
+		},
+	}
+
+	expected := []ServiceAndVersion{
+		{"FOO", "1.2.3", "", 0, false},
+		{"BAR", "^2.3", "", 9003, false},
+		{"BAZ", "", "", 0, false},
+		{"QUX", "^1.0", "2.13", 0, false},
+		{"QUUX", "", "3", 0, false},
+	}
+	if services := sm.requestedServicesAndProfiles(); !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	valid := Profiles{"PROFILE": {"FOO", "FOO_2.13", "BAR:1.2.3", "BAZ_2.12:^1.0", "NAME_WITH_UNDERSCORES:~2.1"}}
+	if err := validateProfiles(valid); err != nil {
+		t.Errorf("expected %v to be valid, got %s", valid, err)
+	}
+
+	for _, entry := range []string{"FOO:", "FOO_2.13:", "BAR: ", ":1.0.0", ""} {
+		if err := validateProfiles(Profiles{"PROFILE": {entry}}); err == nil || !strings.Contains(err.Error(), "profile PROFILE") {
+			t.Errorf("expected %q to be rejected, got %v", entry, err)
+		}
+	}
 }
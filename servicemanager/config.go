@@ -6,12 +6,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
 type ArtifactoryUrls struct {
-	PingUrl string
-	RepoUrl string
+	PingUrl          string
+	RepoUrl          string
+	FallbackRepoUrls []string // tried in order when something isn't in RepoUrl
 }
 
 // @todo set the defaults at build time maybe, the same way we do the version?
@@ -23,6 +26,51 @@ var DefaultArtifactoryUrls = ArtifactoryUrls{
 type Services map[string]Service
 type Profiles map[string][]string
 
+// Profile entries are either a service, or SERVICE:VERSION to pin it to a version or a range of them (see
+// versionConstraint), e.g. CART_BACKEND:^2.3. As on the command line the service can have a scala version too,
+// e.g. CART_BACKEND_2.13:^2.3
+func parseProfileEntry(entry string) (string, string, string) {
+	service, version, _ := strings.Cut(entry, ":")
+	if matches := profileScalaSuffix.FindStringSubmatch(service); matches != nil {
+		return matches[1], matches[2], version
+	}
+	return service, "", version
+}
+
+var profileScalaSuffix = regexp.MustCompile(`^(.+?)_(2\.\d{2}|3)$`)
+
+// the services in a profile, without their versions
+func profileServiceIds(profile []string) []string {
+	ids := []string{}
+	for _, entry := range profile {
+		service, _, _ := parseProfileEntry(entry)
+		ids = append(ids, service)
+	}
+	return ids
+}
+
+// checks every profile entry can be parsed, an entry ending in : is a mistake rather than the latest version
+func validateProfiles(profiles Profiles) error {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, entry := range profiles[name] {
+			service, _, version := parseProfileEntry(entry)
+			if service == "" {
+				return fmt.Errorf("profile %s has an entry without a service: %q", name, entry)
+			}
+			if strings.Contains(entry, ":") && strings.TrimSpace(version) == "" {
+				return fmt.Errorf("profile %s has an entry without a version after the ':': %q", name, entry)
+			}
+		}
+	}
+	return nil
+}
+
 func loadServices(configPath string) (*Services, error) {
 	var services Services
 	
@@ -123,7 +171,41 @@ func loadProfiles(configPath string) (*Profiles, error) {
 	defer file.Close()
 
 	err = json.NewDecoder(file).Decode(&profiles)
-	return &profiles, err
+	if err != nil {
+		return &profiles, err
+	}
+	return &profiles, validateProfiles(profiles)
+}
+
+// loads the (optional) environment variables for each profile. These are kept out of profiles.json
+// so older versions of sm2 can still read it.
+func loadProfileEnv(configPath string) (map[string]map[string]string, error) {
+	profileEnv := map[string]map[string]string{}
+
+	file, err := os.Open(path.Join(configPath, "profile-env.json"))
+	if os.IsNotExist(err) {
+		return profileEnv, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&profileEnv)
+	return profileEnv, err
+}
+
+// names in profile-env.json that aren't profiles in profiles.json, usually a typo or a profile that's been
+// renamed or removed, since the two files are kept in sync by hand
+func unknownProfileEnv(profiles Profiles, profileEnv map[string]map[string]string) []string {
+	unknown := []string{}
+	for name := range profileEnv {
+		if _, ok := profiles[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
 }
 
 // loads config.json which contains repo urls etc
@@ -131,10 +213,11 @@ func loadRepoConfig(configPath string) (ArtifactoryUrls, error) {
 
 	// structs for unmarshalling config.json into...
 	type repoConfig struct {
-		Protocol     string            `json:"protocol"`
-		Host         string            `json:"host"`
-		RepoMappings map[string]string `json:"repoMappings"`
-		Ping         string            `json:"ping"`
+		Protocol      string            `json:"protocol"`
+		Host          string            `json:"host"`
+		RepoMappings  map[string]string `json:"repoMappings"`
+		FallbackRepos []string          `json:"fallbackRepos"` // names from repoMappings, or full urls
+		Ping          string            `json:"ping"`
 	}
 
 	type smConfig struct {
@@ -162,6 +245,15 @@ func loadRepoConfig(configPath string) (ArtifactoryUrls, error) {
 		urls.RepoUrl = fmt.Sprintf("%s://%s/%s", config.Artifactory.Protocol, config.Artifactory.Host, repoPath)
 	}
 
+	for _, repo := range config.Artifactory.FallbackRepos {
+		if repoPath, ok := config.Artifactory.RepoMappings[repo]; ok {
+			repo = fmt.Sprintf("%s://%s/%s", config.Artifactory.Protocol, config.Artifactory.Host, repoPath)
+		} else if !strings.Contains(repo, "://") {
+			continue // not a mapping we know about
+		}
+		urls.FallbackRepoUrls = append(urls.FallbackRepoUrls, strings.TrimSuffix(repo, "/"))
+	}
+
 	if config.Artifactory.Ping != "" {
 		urls.PingUrl = fmt.Sprintf("%s://%s/%s", config.Artifactory.Protocol, config.Artifactory.Host, config.Artifactory.Ping)
 	}
@@ -34,6 +34,14 @@ func (sm *ServiceManager) showDebug(serviceName string) {
 	}
 
 	fmt.Printf("%s: version %s\n Installed at %s on %s\n", installFile.Service, installFile.Version, installFile.Path, installFile.Created)
+	if installFile.Repo != "" {
+		fmt.Printf(" Installed from %s\n", installFile.Repo)
+	}
+	for _, v := range installedVersions(installFile) {
+		if v.Path != installFile.Path {
+			fmt.Printf(" Version %s is also installed at %s\n", v.Version, v.Path)
+		}
+	}
 
 	// check state file
 	fmt.Println("Checking .state file...")
@@ -55,6 +63,12 @@ func (sm *ServiceManager) showDebug(serviceName string) {
 	for _, arg := range stateFile.Args {
 		fmt.Printf("\t- %s\n", arg)
 	}
+	if len(stateFile.Env) > 0 {
+		fmt.Printf("And the following environment variables (secrets are hidden):\n")
+		for _, env := range envList(stateFile.Env) {
+			fmt.Printf("\t- %s\n", env)
+		}
+	}
 	// check pid
 	fmt.Printf("Checking pid: %d is running...\n", stateFile.Pid)
 	procs := sm.Platform.PidLookup()
@@ -0,0 +1,80 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tracks the outcome of starting a service so any services that depend on it know when they can start
+type startResult struct {
+	done chan struct{}
+	err  error
+}
+
+// Orders a list of services so that anything listed in a service's `dependsOn` is started before it.
+// Only dependencies that are part of the same batch are considered, anything else is assumed to be
+// running already (or not needed). The requested order is kept where dependencies allow it and
+// duplicates are dropped. Returns an error describing the loop if the dependencies are circular.
+func orderByDependencies(services []ServiceAndVersion, config map[string]Service) ([]ServiceAndVersion, error) {
+
+	requested := map[string]ServiceAndVersion{}
+	for _, s := range services {
+		if _, seen := requested[s.service]; !seen {
+			requested[s.service] = s
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := map[string]int{}
+	ordered := []ServiceAndVersion{}
+
+	var visit func(service string, path []string) error
+	visit = func(service string, path []string) error {
+		switch marks[service] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(append(path, service), " -> "))
+		}
+
+		marks[service] = visiting
+		for _, dep := range config[service].DependsOn {
+			if _, ok := requested[dep]; ok {
+				if err := visit(dep, append(path, service)); err != nil {
+					return err
+				}
+			}
+		}
+		marks[service] = visited
+		ordered = append(ordered, requested[service])
+		return nil
+	}
+
+	for _, s := range services {
+		if err := visit(s.service, []string{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// blocks until all of a service's dependencies in the current batch have finished starting.
+// returns an error if any of them failed to become healthy.
+func (sm *ServiceManager) awaitDependencies(serviceName string, results map[string]*startResult) error {
+	for _, dep := range sm.Services[serviceName].DependsOn {
+		result, ok := results[dep]
+		if !ok {
+			continue
+		}
+		sm.progress.update(serviceName, 0, "Waiting")
+		<-result.done
+		if result.err != nil {
+			return fmt.Errorf("dependency %s failed to start", dep)
+		}
+	}
+	return nil
+}
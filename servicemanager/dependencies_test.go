@@ -0,0 +1,76 @@
+package servicemanager
+
+import (
+	"testing"
+)
+
+func TestOrderByDependencies(t *testing.T) {
+	config := map[string]Service{
+		"FRONTEND": {Id: "FRONTEND", DependsOn: []string{"BACKEND", "STUB"}},
+		"BACKEND":  {Id: "BACKEND", DependsOn: []string{"STUB", "NOT_REQUESTED"}},
+		"STUB":     {Id: "STUB"},
+		"OTHER":    {Id: "OTHER"},
+	}
+
+	requested := []ServiceAndVersion{
+		{"FRONTEND", "", "", 0, false},
+		{"OTHER", "", "", 0, false},
+		{"BACKEND", "1.2.3", "", 0, false},
+		{"STUB", "", "", 0, false},
+		{"FRONTEND", "", "", 0, false},
+	}
+
+	ordered, err := orderByDependencies(requested, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []ServiceAndVersion{
+		{"STUB", "", "", 0, false},
+		{"BACKEND", "1.2.3", "", 0, false},
+		{"FRONTEND", "", "", 0, false},
+		{"OTHER", "", "", 0, false},
+	}
+
+	if len(ordered) != len(expected) {
+		t.Fatalf("expected %d services, got %d: %v", len(expected), len(ordered), ordered)
+	}
+
+	for i := range expected {
+		if ordered[i] != expected[i] {
+			t.Errorf("position %d: expected %v, got %v", i, expected[i], ordered[i])
+		}
+	}
+}
+
+func TestOrderByDependenciesDetectsCycles(t *testing.T) {
+	config := map[string]Service{
+		"FOO": {Id: "FOO", DependsOn: []string{"BAR"}},
+		"BAR": {Id: "BAR", DependsOn: []string{"BAZ"}},
+		"BAZ": {Id: "BAZ", DependsOn: []string{"FOO"}},
+	}
+
+	_, err := orderByDependencies([]ServiceAndVersion{{"FOO", "", "", 0, false}, {"BAR", "", "", 0, false}, {"BAZ", "", "", 0, false}}, config)
+	if err == nil {
+		t.Fatal("expected a cycle to be reported")
+	}
+
+	if err.Error() != "dependency cycle detected: FOO -> BAR -> BAZ -> FOO" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
+
+func TestOrderByDependenciesIgnoresCyclesOutsideBatch(t *testing.T) {
+	config := map[string]Service{
+		"FOO": {Id: "FOO", DependsOn: []string{"BAR"}},
+		"BAR": {Id: "BAR", DependsOn: []string{"FOO"}},
+	}
+
+	ordered, err := orderByDependencies([]ServiceAndVersion{{"FOO", "", "", 0, false}}, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(ordered) != 1 || ordered[0].service != "FOO" {
+		t.Errorf("expected just FOO, got %v", ordered)
+	}
+}
@@ -0,0 +1,145 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"sm2/ledger"
+)
+
+const (
+	diagnosisLogBytes = 64 * 1024 // how much of the end of stdout.log to search for a cause
+	diagnosisLogLines = 5         // how many lines of the log to show when we can't work out the cause
+)
+
+// A service that was started but never became healthy, along with our best guess as to why.
+type startupFailure struct {
+	reason  string   // what happened, e.g. `exited with exit code 1`
+	cause   string   // the likely cause, if we recognised one in the logs
+	logTail []string // the last few lines of stdout.log
+}
+
+func (f startupFailure) Error() string {
+	if f.cause == "" {
+		return f.reason
+	}
+	return fmt.Sprintf("%s, %s", f.reason, f.cause)
+}
+
+// a known cause of startup failures, and the log output it's recognised by
+type failureCause struct {
+	pattern  *regexp.Regexp
+	describe func(match []string, state ledger.StateFile) string
+}
+
+var failureCauses = []failureCause{
+	{
+		regexp.MustCompile(`Address already in use|java\.net\.BindException`),
+		func(_ []string, state ledger.StateFile) string {
+			return fmt.Sprintf("port %d is already in use", state.Port)
+		},
+	},
+	{
+		regexp.MustCompile(`No configuration setting found for key '([^']+)'`),
+		func(match []string, _ ledger.StateFile) string {
+			return fmt.Sprintf("config key '%s' is missing", match[1])
+		},
+	},
+	{
+		regexp.MustCompile(`java\.lang\.OutOfMemoryError`),
+		func(_ []string, _ ledger.StateFile) string {
+			return "the JVM ran out of memory"
+		},
+	},
+	{
+		regexp.MustCompile(`UnsupportedClassVersionError|compiled by a more recent version of the Java Runtime`),
+		func(_ []string, state ledger.StateFile) string {
+			return fmt.Sprintf("it needs a newer version of java, set javaVersion for %s in services.json or javaHomes/javaHome in sm2.json (see sm2 -diagnostic)", state.Service)
+		},
+	},
+	{
+		regexp.MustCompile(`MongoTimeoutException|MongoSocketOpenException|Connection refused.*:27017`),
+		func(_ []string, _ ledger.StateFile) string {
+			return "couldn't connect to mongo, is it running?"
+		},
+	},
+}
+
+// Works out why a service failed to start, using how the process exited (if it has) and its logs.
+func diagnoseStartupFailure(state ledger.StateFile, reason string) startupFailure {
+	failure := startupFailure{reason: reason}
+
+	lines := readLogTail(path.Join(state.Path, "logs", "stdout.log"), diagnosisLogBytes)
+	failure.cause = classifyFailure(lines, state)
+
+	if exit, ok := lookupExit(state.Pid); ok && failure.cause == "" && killedBy(exit, syscall.SIGKILL) {
+		failure.cause = "it was killed, possibly by the OS for using too much memory"
+	}
+
+	if len(lines) > diagnosisLogLines {
+		lines = lines[len(lines)-diagnosisLogLines:]
+	}
+	failure.logTail = lines
+	return failure
+}
+
+// returns the first known cause found in the logs, searching from the most recent line backwards
+func classifyFailure(lines []string, state ledger.StateFile) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, c := range failureCauses {
+			if match := c.pattern.FindStringSubmatch(lines[i]); match != nil {
+				return c.describe(match, state)
+			}
+		}
+	}
+	return ""
+}
+
+// reads (up to) the last n bytes of a log file, split into non-empty lines
+func readLogTail(logPath string, n int64) []string {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return []string{}
+	}
+	defer file.Close()
+
+	partial := false
+	if info, err := file.Stat(); err == nil && info.Size() > n {
+		file.Seek(-n, io.SeekEnd)
+		partial = true
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return []string{}
+	}
+
+	lines := []string{}
+	for i, line := range strings.Split(string(content), "\n") {
+		// the first line is probably only part of a line if we've skipped to the end
+		if (i == 0 && partial) || strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+	}
+	return lines
+}
+
+// prints the error for each service that failed to start, including the end of its logs if we couldn't work out the cause
+func printStartupErrors(errs map[string]error, out io.Writer) {
+	for service, err := range errs {
+		fmt.Fprintf(out, "  %s: %s\n", service, err.Error())
+
+		if failure, ok := err.(startupFailure); ok && failure.cause == "" && len(failure.logTail) > 0 {
+			fmt.Fprintf(out, "    last lines of stdout.log (see sm2 -logs %s):\n", service)
+			for _, line := range failure.logTail {
+				fmt.Fprintf(out, "      \033[2m%s\033[0m\n", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package servicemanager
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	state := ledger.StateFile{Service: "FOO", Port: 9000}
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"org.jboss.netty.channel.ChannelException: Failed to bind to: /0.0.0.0:9000", ""},
+		{"Caused by: java.net.BindException: Address already in use", "port 9000 is already in use"},
+		{"com.typesafe.config.ConfigException$Missing: No configuration setting found for key 'microservice.services.auth'", "config key 'microservice.services.auth' is missing"},
+		{"Exception in thread \"main\" java.lang.OutOfMemoryError: Java heap space", "the JVM ran out of memory"},
+		{"java.lang.UnsupportedClassVersionError: Main has been compiled by a more recent version of the Java Runtime", "it needs a newer version of java, set javaVersion for FOO in services.json or javaHomes/javaHome in sm2.json (see sm2 -diagnostic)"},
+		{"com.mongodb.MongoTimeoutException: Timed out after 30000 ms while waiting to connect", "couldn't connect to mongo, is it running?"},
+		{"INFO application started", ""},
+	}
+
+	for _, test := range tests {
+		if got := classifyFailure([]string{test.line}, state); got != test.expected {
+			t.Errorf("classifyFailure(%q) = %q, expected %q", test.line, got, test.expected)
+		}
+	}
+}
+
+func TestClassifyFailurePrefersMostRecentCause(t *testing.T) {
+	lines := []string{
+		"java.net.BindException: Address already in use",
+		"java.lang.OutOfMemoryError: Metaspace",
+	}
+	if got := classifyFailure(lines, ledger.StateFile{Port: 9000}); got != "the JVM ran out of memory" {
+		t.Errorf("expected the last cause to be used, got %q", got)
+	}
+}
+
+func TestReadLogTail(t *testing.T) {
+	logFile := path.Join(t.TempDir(), "stdout.log")
+	os.WriteFile(logFile, []byte("first line\nsecond line\n\nthird line\n"), 0644)
+
+	lines := readLogTail(logFile, 1024)
+	if strings.Join(lines, "|") != "first line|second line|third line" {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+
+	// partial lines at the start should be dropped
+	lines = readLogTail(logFile, 16)
+	if strings.Join(lines, "|") != "third line" {
+		t.Errorf("unexpected lines when reading the tail: %v", lines)
+	}
+
+	if lines := readLogTail(path.Join(t.TempDir(), "missing.log"), 1024); len(lines) != 0 {
+		t.Errorf("expected no lines for a missing file, got %v", lines)
+	}
+}
+
+func TestDiagnoseStartupFailure(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(path.Join(dir, "logs"), 0755)
+	log := "Starting...\n"
+	for i := 0; i < 10; i++ {
+		log += "at play.core.server.ProdServerStart\n"
+	}
+	os.WriteFile(path.Join(dir, "logs", "stdout.log"), []byte(log), 0644)
+
+	failure := diagnoseStartupFailure(ledger.StateFile{Path: dir, Pid: -1}, "exited with exit code 1")
+	if failure.Error() != "exited with exit code 1" {
+		t.Errorf("unexpected error: %s", failure.Error())
+	}
+	if len(failure.logTail) != diagnosisLogLines {
+		t.Errorf("expected %d lines of log, got %d", diagnosisLogLines, len(failure.logTail))
+	}
+
+	out := bytes.Buffer{}
+	printStartupErrors(map[string]error{"FOO": failure}, &out)
+	if !strings.Contains(out.String(), "FOO: exited with exit code 1") || !strings.Contains(out.String(), "ProdServerStart") {
+		t.Errorf("expected the log tail to be printed, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	printStartupErrors(map[string]error{"BAR": errors.New("Not available offline")}, &out)
+	if out.String() != "  BAR: Not available offline\n" {
+		t.Errorf("unexpected output for a plain error: %q", out.String())
+	}
+}
@@ -21,6 +21,7 @@ func RunDiagnostics(config ServiceManagerConfig, noProgress bool) {
 
 	startStatus(CompJava, noProgress)
 	checkJava(noProgress)
+	listJdks(config, noProgress)
 
 	startStatus(CompGit, noProgress)
 	checkGit(noProgress)
@@ -91,6 +92,13 @@ func checkJava(noProgress bool) {
 	}
 }
 
+// lists the JDKs that services can be run with, see javaVersion in services.json
+func listJdks(config ServiceManagerConfig, noProgress bool) {
+	for _, j := range discoverJdks(config.JavaHomes) {
+		printStatus(CompJava, StatusInfo, fmt.Sprintf("java %d: %s", j.major, j.home), noProgress)
+	}
+}
+
 func javaPath() string {
 	javaHome, javaHomeDefined := os.LookupEnv("JAVA_HOME")
 	if javaHomeDefined {
@@ -0,0 +1,129 @@
+package servicemanager
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+
+	"sm2/ledger"
+)
+
+const REDACTED = "********"
+
+// variables that look like they hold secrets, these are redacted in the .state file
+var secretEnvName = regexp.MustCompile(`(?i)secret|password|passwd|token|credential|private|api_?key|auth`)
+
+// Works out the extra environment variables to start a service with. Later sources override earlier ones:
+// JAVA_HOME (see javaHomeFor), the env in services.json, the env of any profiles being started that include
+// the service, then --env (KEY=VALUE first, then SERVICE:KEY=VALUE).
+func (sm *ServiceManager) serviceEnv(service Service) (map[string]string, error) {
+	env, err := sm.configEnv(service)
+	if err != nil {
+		return env, err
+	}
+	setEnv(env, sm.cliEnv(service))
+	return env, nil
+}
+
+// The env to use when restarting a service: the env from config, then the values recorded in the .state file on top
+// (so a restart keeps an --env override, the same as it keeps the args), then --env if it was given this time.
+// Redacted values can't be recovered so the config value is used for those.
+func (sm *ServiceManager) relaunchEnv(service Service, state ledger.StateFile) (map[string]string, error) {
+	env, err := sm.configEnv(service)
+	if err != nil {
+		return env, err
+	}
+	for k, v := range state.Env {
+		if v != REDACTED && v == redactEnvValue(k, v) {
+			env[k] = v
+		}
+	}
+	setEnv(env, sm.cliEnv(service))
+	return env, nil
+}
+
+// the env from JAVA_HOME, services.json and the profiles being started
+func (sm *ServiceManager) configEnv(service Service) (map[string]string, error) {
+	env := map[string]string{}
+
+	javaHome, err := sm.javaHomeFor(service)
+	if err != nil {
+		return env, err
+	}
+	if javaHome != "" {
+		env["JAVA_HOME"] = javaHome
+	}
+	setEnv(env, service.Env)
+
+	for _, name := range sm.Commands.ExtraServices {
+		if !slices.Contains(profileServiceIds(sm.Profiles[name]), service.Id) {
+			continue
+		}
+		setEnv(env, sm.ProfileEnv[name])
+	}
+	return env, nil
+}
+
+// the env from --env, KEY=VALUE first so SERVICE:KEY=VALUE wins
+func (sm *ServiceManager) cliEnv(service Service) map[string]string {
+	env := map[string]string{}
+	setEnv(env, sm.Commands.Env[""])
+	setEnv(env, sm.Commands.Env[service.Id])
+	return env
+}
+
+func setEnv(env map[string]string, vars map[string]string) {
+	for k, v := range vars {
+		env[k] = v
+	}
+}
+
+// The environment for a service's process, i.e. sm2's own environment plus the extra variables.
+// If JAVA_HOME is set its bin dir goes first on the PATH too, so anything calling `java` gets the right one.
+func processEnv(env map[string]string) []string {
+	processEnv := append(os.Environ(), envList(env)...)
+	if javaHome, ok := env["JAVA_HOME"]; ok {
+		// java from JAVA_HOME goes in front of the PATH the service would otherwise get, including one from its env config
+		pathEnv, ok := env["PATH"]
+		if !ok {
+			pathEnv = os.Getenv("PATH")
+		}
+		processEnv = append(processEnv, "PATH="+path.Join(javaHome, "bin")+string(os.PathListSeparator)+pathEnv)
+	}
+	return processEnv
+}
+
+// formats the variables as KEY=VALUE, sorted so they're applied in a consistent order
+func envList(env map[string]string) []string {
+	list := []string{}
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// returns a copy of the env with anything that looks like a secret hidden, including passwords in urls
+func redactEnv(env map[string]string) map[string]string {
+	redacted := map[string]string{}
+	for k, v := range env {
+		redacted[k] = redactEnvValue(k, v)
+	}
+	return redacted
+}
+
+func redactEnvValue(name string, value string) string {
+	if secretEnvName.MatchString(name) {
+		return REDACTED
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return value
+}
@@ -0,0 +1,238 @@
+package servicemanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func extractTarGzFile(tarball string, outdir string) (string, error) {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return extractTarGz(file, outdir)
+}
+
+// Extracts a .tgz to a temp dir next to where it's going and then moves it into place, so a failure part way through
+// doesn't leave a half extracted service in outdir. Entries that would end up outside of outdir (../ in their name,
+// or symlinks pointing out of it) fail the whole thing. Returns the service dir.
+func extractTarGz(r io.Reader, outdir string) (string, error) {
+	staging, err := os.MkdirTemp(outdir, ".extract-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	// used to determin the serviceDir
+	dirsSeen := map[string]uint8{}
+
+	// dir mtimes are set at the end, since adding files to them changes it
+	dirTimes := map[string]time.Time{}
+
+	tarReader := tar.NewReader(gz)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read tarball: %s", err)
+		}
+
+		target, err := tarEntryPath(staging, header.Name)
+		if err != nil {
+			return "", err
+		}
+		if target == staging {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			// create folder if required
+			if err := makeParentDirs(staging, target); err != nil {
+				return "", fmt.Errorf("failed to create dir for %s: %s", header.Name, err)
+			}
+			// anything already there is replaced, rather than written through if its a symlink
+			if info, err := os.Lstat(target); err == nil && !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+				if err := os.RemoveAll(target); err != nil {
+					return "", err
+				}
+			}
+		default:
+			// devices, fifos etc have no place in a service
+			continue
+		}
+
+		switch header.Typeflag {
+
+		case tar.TypeDir:
+			// TODO: track dirs created so we can determin where exactly the app is
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", fmt.Errorf("failed to create dir %s: %s", header.Name, err)
+			}
+			// make sure we can still write to it
+			if err := os.Chmod(target, header.FileInfo().Mode().Perm()|0700); err != nil {
+				return "", err
+			}
+			if !header.ModTime.IsZero() {
+				dirTimes[target] = header.ModTime
+			}
+			continue
+
+		case tar.TypeReg:
+			// write the file
+			if err := extractFile(tarReader, target, header.FileInfo().Mode().Perm()); err != nil {
+				return "", err
+			}
+
+		case tar.TypeSymlink:
+			if err := checkSymlink(staging, target, header.Linkname); err != nil {
+				return "", fmt.Errorf("tarball entry %s: %s", header.Name, err)
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return "", err
+			}
+
+		case tar.TypeLink:
+			// hardlinks are to another file in the tarball, which has to have been extracted already
+			linkTarget, err := tarEntryPath(staging, header.Linkname)
+			if err != nil {
+				return "", err
+			}
+			if info, err := os.Lstat(linkTarget); err != nil || !info.Mode().IsRegular() {
+				return "", fmt.Errorf("tarball entry %s is a hardlink to %s, which isn't a file in the tarball", header.Name, header.Linkname)
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return "", err
+			}
+		}
+
+		// theres no portable way to set the mtime of a symlink itself, and a hardlink shares its target's
+		if header.Typeflag == tar.TypeReg && !header.ModTime.IsZero() {
+			os.Chtimes(target, header.ModTime, header.ModTime)
+		}
+
+		rel, _ := filepath.Rel(staging, filepath.Dir(target))
+		rootDir := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		dirsSeen[rootDir] = 1
+	}
+
+	for dir, mtime := range dirTimes {
+		os.Chtimes(dir, mtime, mtime)
+	}
+
+	// everything's extracted, move it into place
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		target := path.Join(outdir, entry.Name())
+		if err := os.RemoveAll(target); err != nil {
+			return "", err
+		}
+		if err := os.Rename(path.Join(staging, entry.Name()), target); err != nil {
+			return "", err
+		}
+	}
+
+	// based on the directories we've had to make, figure out which one the service is in
+	// we're assuming theres only one, this could be better
+	var serviceDir string
+
+	delete(dirsSeen, ".")
+	for k := range dirsSeen {
+		// TODO: regex it or something? maybe inc the count every times its seen and go with the largest?
+		//       if we know what the bin dir is (from services.json) we could use that too
+		serviceDir = path.Join(outdir, k)
+	}
+
+	return serviceDir, nil
+}
+
+// where a tarball entry should be extracted to, or an error if that's outside of dir
+func tarEntryPath(dir string, name string) (string, error) {
+	if path.IsAbs(name) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("tarball entry %s has an absolute path", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if !isWithin(dir, target) {
+		return "", fmt.Errorf("tarball entry %s would be extracted outside of the install dir", name)
+	}
+	return target, nil
+}
+
+func isWithin(dir string, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Creates the dirs an entry goes in. None of them can be a symlink, otherwise a symlink pointing somewhere else in
+// the tarball could be used to get a file written outside of it.
+func makeParentDirs(root string, target string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", part)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a dir", part)
+		}
+	}
+	return nil
+}
+
+// symlinks have to be relative and point somewhere inside the tarball
+func checkSymlink(root string, link string, linkname string) error {
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("symlink to absolute path %s", linkname)
+	}
+	if !isWithin(root, filepath.Join(filepath.Dir(link), filepath.FromSlash(linkname))) {
+		return fmt.Errorf("symlink to %s points outside of the install dir", linkname)
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, filename string, mode os.FileMode) error {
+	outfile, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %s", filename, err)
+	}
+	defer outfile.Close()
+
+	if _, err := io.Copy(outfile, r); err != nil {
+		return fmt.Errorf("failed to write to file %s: %s", filename, err)
+	}
+	// fix up the permissions
+	return outfile.Chmod(mode)
+}
@@ -0,0 +1,169 @@
+package servicemanager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func file(name string, content string) tarEntry {
+	return tarEntry{tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}, content}
+}
+
+func dir(name string) tarEntry {
+	return tarEntry{tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}, ""}
+}
+
+func symlink(name string, target string) tarEntry {
+	return tarEntry{tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target}, ""}
+}
+
+func hardlink(name string, target string) tarEntry {
+	return tarEntry{tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target}, ""}
+}
+
+// builds a .tgz in memory
+func tarball(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := e.header
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf
+}
+
+// extracts into a dir inside another one, so anything that escapes can be spotted
+func extractForTest(t *testing.T, entries ...tarEntry) (string, string, error) {
+	parent := t.TempDir()
+	outdir := path.Join(parent, "install")
+	os.MkdirAll(outdir, 0755)
+	serviceDir, err := extractTarGz(tarball(t, entries...), outdir)
+	return outdir, serviceDir, err
+}
+
+func assertRejected(t *testing.T, outdir string, err error, expected string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected an error containing %q, got %v", expected, err)
+	}
+	assertNothingLeftBehind(t, outdir)
+	if entries, _ := os.ReadDir(path.Dir(outdir)); len(entries) != 1 {
+		t.Errorf("expected nothing to be written outside of the install dir, found %d entries", len(entries))
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	start := file("foo-1.0.0/lib/start.sh", "#!/bin/sh")
+	start.header.Mode = 0755
+	start.header.ModTime = mtime
+	libDir := dir("foo-1.0.0/lib/")
+	libDir.header.ModTime = mtime
+
+	outdir, serviceDir, err := extractForTest(t,
+		dir("foo-1.0.0/"),
+		libDir,
+		start,
+		dir("foo-1.0.0/bin/"),
+		symlink("foo-1.0.0/bin/foo", "../lib/start.sh"),
+		hardlink("foo-1.0.0/bin/foo-copy", "foo-1.0.0/lib/start.sh"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceDir != path.Join(outdir, "foo-1.0.0") {
+		t.Errorf("expected the service dir to be foo-1.0.0, got %s", serviceDir)
+	}
+
+	if target, err := os.Readlink(path.Join(serviceDir, "bin", "foo")); err != nil || target != "../lib/start.sh" {
+		t.Errorf("expected bin/foo to be a symlink to ../lib/start.sh, got %s (%v)", target, err)
+	}
+	if content, err := os.ReadFile(path.Join(serviceDir, "bin", "foo-copy")); err != nil || string(content) != "#!/bin/sh" {
+		t.Errorf("expected bin/foo-copy to be a hardlink to the start script, got %q (%v)", content, err)
+	}
+
+	info, err := os.Stat(path.Join(serviceDir, "lib", "start.sh"))
+	if err != nil || info.Mode().Perm() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Errorf("expected start.sh to keep its mode and mtime, got %v", info)
+	}
+	if info, err := os.Stat(path.Join(serviceDir, "lib")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("expected lib to keep its mtime, got %v", info)
+	}
+}
+
+func TestExtractTarGzRejectsPathTraversal(t *testing.T) {
+	outdir, _, err := extractForTest(t, file("foo-1.0.0/ok", "ok"), file("foo-1.0.0/../../evil", "evil"))
+	assertRejected(t, outdir, err, "outside of the install dir")
+}
+
+func TestExtractTarGzRejectsAbsolutePaths(t *testing.T) {
+	outdir, _, err := extractForTest(t, file("/tmp/evil", "evil"))
+	assertRejected(t, outdir, err, "absolute path")
+}
+
+func TestExtractTarGzRejectsSymlinksOutOfTheInstall(t *testing.T) {
+	outdir, _, err := extractForTest(t, symlink("foo-1.0.0/etc", "/etc"))
+	assertRejected(t, outdir, err, "absolute path")
+
+	outdir, _, err = extractForTest(t, symlink("foo-1.0.0/up", "../../.."))
+	assertRejected(t, outdir, err, "points outside")
+}
+
+func TestExtractTarGzDoesntWriteThroughSymlinks(t *testing.T) {
+	// the symlink is inside the tarball, but the second one would end up outside of it if we followed the first
+	outdir, _, err := extractForTest(t,
+		symlink("foo-1.0.0/here", "."),
+		symlink("foo-1.0.0/here/up", ".."),
+	)
+	assertRejected(t, outdir, err, "is a symlink")
+
+	outdir, _, err = extractForTest(t,
+		symlink("foo-1.0.0/lib", "."),
+		file("foo-1.0.0/lib/foo.jar", "jar"),
+	)
+	assertRejected(t, outdir, err, "is a symlink")
+}
+
+func TestExtractTarGzReplacesSymlinksRatherThanFollowingThem(t *testing.T) {
+	outdir, serviceDir, err := extractForTest(t,
+		file("foo-1.0.0/real", "real"),
+		symlink("foo-1.0.0/link", "real"),
+		file("foo-1.0.0/link", "replaced"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path.Join(serviceDir, "real")); string(content) != "real" {
+		t.Errorf("expected the file the symlink pointed to not to be changed, got %q", content)
+	}
+	if info, err := os.Lstat(path.Join(outdir, "foo-1.0.0", "link")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected the symlink to be replaced by a file")
+	}
+}
+
+func TestExtractTarGzRejectsHardlinksOutOfTheInstall(t *testing.T) {
+	outdir, _, err := extractForTest(t, hardlink("foo-1.0.0/passwd", "../../etc/passwd"))
+	assertRejected(t, outdir, err, "outside of the install dir")
+
+	outdir, _, err = extractForTest(t, hardlink("foo-1.0.0/missing", "foo-1.0.0/nothing"))
+	assertRejected(t, outdir, err, "isn't a file in the tarball")
+}
@@ -15,12 +15,22 @@ Start a specific version of a service:
 (alternatively, or to start specific versions of many services):
     sm2 --start CATALOGUE_FRONTEND_2.12:0.499.0 INTERNAL_AUTH:0.32.0
 
+Record what's running and start the same services elsewhere:
+    sm2 --snapshot > env.lock.json
+    sm2 --start --from-lock env.lock.json
+
 Force a service to be redownloaded:
    sm2 --start AUTH --clean
 
 Delete all cached service versions:
    sm2 --clean-cache
 
+Delete all but the newest 2 installed versions of each service:
+   sm2 --clean-cache --keep-versions 2
+
+Check the installed services haven't been corrupted:
+   sm2 --verify-installs
+
 Show all running services:
     sm2 -s
 
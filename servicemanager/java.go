@@ -0,0 +1,151 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a JDK found on the machine (or set in sm2.json)
+type jdk struct {
+	major int
+	home  string
+}
+
+// where JDKs are usually installed, ~ is replaced with the user's home dir
+var jdkLocations = []string{
+	"/usr/lib/jvm/*",
+	"/usr/java/*",
+	"/opt/java/*",
+	"/Library/Java/JavaVirtualMachines/*/Contents/Home",
+	"~/.sdkman/candidates/java/*",
+	"~/.jdks/*",
+	"~/Library/Java/JavaVirtualMachines/*/Contents/Home",
+}
+
+var javaVersionRegex = regexp.MustCompile(`(?m)^JAVA_VERSION="([^"]+)"`)
+
+// Finds the installed JDKs, including any set in javaHomes in sm2.json. Sorted by version (newest first).
+func discoverJdks(javaHomes map[int]string) []jdk {
+	found := []jdk{}
+	seen := map[string]bool{}
+
+	for major, home := range javaHomes {
+		found = append(found, jdk{major, home})
+		seen[home] = true
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	for _, location := range jdkLocations {
+		if strings.HasPrefix(location, "~/") {
+			if homeDir == "" {
+				continue
+			}
+			location = path.Join(homeDir, location[2:])
+		}
+
+		matches, _ := filepath.Glob(location)
+		for _, home := range matches {
+			// sdkman etc have a 'current' symlink, we only want the real thing
+			resolved, err := filepath.EvalSymlinks(home)
+			if err != nil || seen[resolved] || seen[home] {
+				continue
+			}
+			if major, ok := jdkMajorVersion(home); ok {
+				found = append(found, jdk{major, home})
+				seen[resolved] = true
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].major != found[j].major {
+			return found[i].major > found[j].major
+		}
+		return found[i].home < found[j].home
+	})
+	return found
+}
+
+// reads the major version of a JDK from its `release` file
+func jdkMajorVersion(javaHome string) (int, bool) {
+	if !Exists(path.Join(javaHome, "bin", "java")) {
+		return 0, false
+	}
+	release, err := os.ReadFile(path.Join(javaHome, "release"))
+	if err != nil {
+		return 0, false
+	}
+	match := javaVersionRegex.FindSubmatch(release)
+	if match == nil {
+		return 0, false
+	}
+	return parseJavaMajorVersion(string(match[1]))
+}
+
+// 1.8.0_292 is java 8, 11.0.2 is java 11, 21 is java 21...
+func parseJavaMajorVersion(version string) (int, bool) {
+	parts := strings.Split(version, ".")
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	if major == 1 && len(parts) > 1 {
+		major, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, false
+		}
+	}
+	return major, true
+}
+
+// the major version of the java on the path (or $JAVA_HOME), 0 if we can't tell
+func defaultJavaMajorVersion() int {
+	out, err := exec.Command(javaPath(), "-version").CombinedOutput()
+	if err != nil {
+		return 0
+	}
+	match := regexp.MustCompile(`version "([^"]+)"`).FindSubmatch(out)
+	if match == nil {
+		return 0
+	}
+	major, _ := parseJavaMajorVersion(string(match[1]))
+	return major
+}
+
+// Works out which JAVA_HOME to run a service with. Services that need a specific version of java get the JDK
+// from javaHomes in sm2.json, or one we've found installed. Otherwise it's javaHome from sm2.json (if set).
+// Returns "" if the service should just use whatever java is on the path.
+func (sm *ServiceManager) javaHomeFor(service Service) (string, error) {
+	if service.JavaVersion == 0 {
+		return sm.Config.JavaHome, nil
+	}
+
+	if home, ok := sm.Config.JavaHomes[service.JavaVersion]; ok {
+		return home, nil
+	}
+
+	if sm.Config.JavaHome != "" {
+		if major, ok := jdkMajorVersion(sm.Config.JavaHome); ok && major == service.JavaVersion {
+			return sm.Config.JavaHome, nil
+		}
+	}
+
+	for _, j := range discoverJdks(nil) {
+		if j.major == service.JavaVersion {
+			return j.home, nil
+		}
+	}
+
+	if sm.Config.JavaHome == "" && defaultJavaMajorVersion() == service.JavaVersion {
+		return "", nil
+	}
+
+	return "", fmt.Errorf("%s needs java %d but it couldn't be found, install it or add it to javaHomes in sm2.json", service.Id, service.JavaVersion)
+}
@@ -0,0 +1,102 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// makes something that looks enough like a JDK for jdkMajorVersion
+func makeTestJdk(t *testing.T, javaVersion string) string {
+	home := t.TempDir()
+	os.MkdirAll(path.Join(home, "bin"), 0755)
+	os.WriteFile(path.Join(home, "bin", "java"), []byte("#!/bin/sh\n"), 0755)
+	os.WriteFile(path.Join(home, "release"), []byte("IMPLEMENTOR=\"Eclipse Adoptium\"\nJAVA_VERSION=\""+javaVersion+"\"\n"), 0644)
+	return home
+}
+
+func TestParseJavaMajorVersion(t *testing.T) {
+	tests := map[string]int{
+		"1.8.0_292": 8,
+		"11.0.2":    11,
+		"17":        17,
+		"21.0.2":    21,
+	}
+	for version, expected := range tests {
+		if major, ok := parseJavaMajorVersion(version); !ok || major != expected {
+			t.Errorf("expected %s to be java %d, got %d", version, expected, major)
+		}
+	}
+
+	if _, ok := parseJavaMajorVersion("not-a-version"); ok {
+		t.Errorf("expected not-a-version to be rejected")
+	}
+}
+
+func TestJdkMajorVersion(t *testing.T) {
+	home := makeTestJdk(t, "21.0.2")
+	if major, ok := jdkMajorVersion(home); !ok || major != 21 {
+		t.Errorf("expected java 21, got %d", major)
+	}
+
+	os.Remove(path.Join(home, "bin", "java"))
+	if _, ok := jdkMajorVersion(home); ok {
+		t.Errorf("expected a JDK without bin/java to be ignored")
+	}
+}
+
+func TestJavaHomeForUsesJavaHomes(t *testing.T) {
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{
+			JavaHome:  "/opt/default-jdk",
+			JavaHomes: map[int]string{11: "/opt/jdk-11", 21: "/opt/jdk-21"},
+		},
+	}
+
+	home, err := sm.javaHomeFor(Service{Id: "FOO", JavaVersion: 11})
+	if err != nil || home != "/opt/jdk-11" {
+		t.Errorf("expected /opt/jdk-11, got %s (%v)", home, err)
+	}
+
+	home, err = sm.javaHomeFor(Service{Id: "BAR"})
+	if err != nil || home != "/opt/default-jdk" {
+		t.Errorf("expected a service without a javaVersion to use javaHome, got %s (%v)", home, err)
+	}
+}
+
+func TestJavaHomeForUsesMatchingJavaHome(t *testing.T) {
+	jdk := makeTestJdk(t, "17.0.9")
+	sm := ServiceManager{Config: ServiceManagerConfig{JavaHome: jdk}}
+
+	home, err := sm.javaHomeFor(Service{Id: "FOO", JavaVersion: 17})
+	if err != nil || home != jdk {
+		t.Errorf("expected %s, got %s (%v)", jdk, home, err)
+	}
+}
+
+func TestJavaHomeForMissingVersion(t *testing.T) {
+	sm := ServiceManager{Config: ServiceManagerConfig{JavaHome: makeTestJdk(t, "17.0.9")}}
+
+	_, err := sm.javaHomeFor(Service{Id: "FOO", JavaVersion: 999})
+	if err == nil || !strings.Contains(err.Error(), "FOO needs java 999") {
+		t.Errorf("expected an error saying java 999 couldn't be found, got %v", err)
+	}
+}
+
+func TestProcessEnvPutsJavaFirstOnPath(t *testing.T) {
+	env := processEnv(map[string]string{"JAVA_HOME": "/opt/jdk-21", "FOO": "bar"})
+
+	last := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		last[k] = v
+	}
+
+	if last["JAVA_HOME"] != "/opt/jdk-21" || last["FOO"] != "bar" {
+		t.Errorf("expected the service's env to be set, got JAVA_HOME=%s FOO=%s", last["JAVA_HOME"], last["FOO"])
+	}
+	if !strings.HasPrefix(last["PATH"], "/opt/jdk-21/bin"+string(os.PathListSeparator)) {
+		t.Errorf("expected the JDK's bin dir to be first on the PATH, got %s", last["PATH"])
+	}
+}
@@ -0,0 +1,175 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Structures used when --format json is set. These are intended to be consumed by scripts,
+// so fields should only ever be added, never renamed or removed.
+
+type jsonServiceStatus struct {
+	Service        string     `json:"service"`
+	Version        string     `json:"version"`
+	Pid            int        `json:"pid"`
+	Port           int        `json:"port"`
+	Health         health     `json:"health"`
+	Started        *time.Time `json:"started,omitempty"`
+	UptimeSeconds  int64      `json:"uptimeSeconds,omitempty"`
+	HealthcheckUrl string     `json:"healthcheckUrl,omitempty"`
+	RestartPolicy  string     `json:"restartPolicy,omitempty"`
+	Restarts       int        `json:"restarts,omitempty"`
+	LastExit       string     `json:"lastExit,omitempty"`
+}
+
+type jsonUnmanagedPort struct {
+	Pid        int    `json:"pid"`
+	Port       int    `json:"port"`
+	ReservedBy string `json:"reservedBy"`
+}
+
+type jsonProxyRoute struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+type jsonReverseProxy struct {
+	Pid     int              `json:"pid"`
+	Started time.Time        `json:"started"`
+	Routes  []jsonProxyRoute `json:"routes"`
+}
+
+type jsonStatusReport struct {
+	Services     []jsonServiceStatus `json:"services"`
+	Unmanaged    []jsonUnmanagedPort `json:"unmanaged"`
+	ReverseProxy *jsonReverseProxy   `json:"reverseProxy,omitempty"`
+}
+
+type jsonVerifyResult struct {
+	Service string `json:"service"`
+	Running bool   `json:"running"`
+}
+
+type jsonVerifyReport struct {
+	Ok       bool               `json:"ok"`
+	Services []jsonVerifyResult `json:"services"`
+}
+
+type jsonInstallCheck struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Sha256  string `json:"sha256,omitempty"`
+	Status  string `json:"status"`
+}
+
+type jsonPort struct {
+	Port        int    `json:"port"`
+	Service     string `json:"service"`
+	Frontend    bool   `json:"frontend"`
+	DefaultPort int    `json:"defaultPort,omitempty"` // only set if the service was started on a different port
+}
+
+type jsonServiceListing struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	Repo string `json:"repo"`
+}
+
+type jsonProfileListing struct {
+	Id       string   `json:"id"`
+	Services []string `json:"services"`
+}
+
+type jsonSearchResults struct {
+	Services []jsonServiceListing `json:"services"`
+	Profiles []jsonProfileListing `json:"profiles"`
+}
+
+type jsonInstall struct {
+	Service   string    `json:"service"`
+	Artifact  string    `json:"artifact"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Installed time.Time `json:"installed"`
+}
+
+func (sm *ServiceManager) formatJson() bool {
+	return sm.Commands.Format == "json"
+}
+
+// writes a value as indented json
+func printJson(v interface{}, out io.Writer) {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(out, "failed to encode json: %s\n", err)
+	}
+}
+
+// combines the statuses with the matching state files to build the --status report
+func buildStatusReport(statuses []serviceStatus, states []ledger.StateFile, unmanaged []serviceStatus, proxyState ledger.ProxyState, now time.Time) jsonStatusReport {
+	report := jsonStatusReport{
+		Services:  []jsonServiceStatus{},
+		Unmanaged: []jsonUnmanagedPort{},
+	}
+
+	stateLookup := map[string]ledger.StateFile{}
+	for _, state := range states {
+		stateLookup[state.Service] = state
+	}
+
+	for _, s := range statuses {
+		status := jsonServiceStatus{
+			Service: s.service,
+			Version: s.version,
+			Pid:     s.pid,
+			Port:    s.port,
+			Health:  s.health,
+		}
+		if state, ok := stateLookup[s.service]; ok {
+			started := state.Started
+			status.Started = &started
+			status.UptimeSeconds = int64(now.Sub(started).Seconds())
+			status.HealthcheckUrl = state.HealthcheckUrl
+			if status.HealthcheckUrl == "" {
+				status.HealthcheckUrl = defaultHealthcheckUrl(state.Port)
+			}
+			status.RestartPolicy = state.RestartPolicy
+			status.Restarts = state.Restarts
+			status.LastExit = state.LastExit
+		}
+		report.Services = append(report.Services, status)
+	}
+
+	for _, s := range unmanaged {
+		report.Unmanaged = append(report.Unmanaged, jsonUnmanagedPort{Pid: s.pid, Port: s.port, ReservedBy: s.service})
+	}
+
+	if proxyState.Pid > 0 {
+		proxy := jsonReverseProxy{Pid: proxyState.Pid, Started: proxyState.Started, Routes: []jsonProxyRoute{}}
+		for path, target := range proxyState.ProxyPaths {
+			proxy.Routes = append(proxy.Routes, jsonProxyRoute{Path: path, Target: target})
+		}
+		sort.Slice(proxy.Routes, func(i, j int) bool {
+			return proxy.Routes[i].Path < proxy.Routes[j].Path
+		})
+		report.ReverseProxy = &proxy
+	}
+
+	return report
+}
+
+func buildVerifyReport(results []verifyResult) jsonVerifyReport {
+	report := jsonVerifyReport{Ok: allRunning(results), Services: []jsonVerifyResult{}}
+	for _, r := range results {
+		report.Services = append(report.Services, jsonVerifyResult{Service: r.service, Running: r.running})
+	}
+	return report
+}
@@ -0,0 +1,92 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+)
+
+func TestBuildStatusReport(t *testing.T) {
+	now := time.Date(2024, time.Month(3), 1, 12, 0, 0, 0, time.UTC)
+	statuses := []serviceStatus{
+		{pid: 0, port: 27017, service: "MONGO", health: PASS},
+		{pid: 1234, port: 8080, service: "FOO", version: "1.2.3", health: BOOT},
+	}
+	states := []ledger.StateFile{
+		{Service: "FOO", Version: "1.2.3", Pid: 1234, Port: 8080, Started: now.Add(-90 * time.Second)},
+	}
+	unmanaged := []serviceStatus{
+		{pid: 5555, port: 9000, service: "BAR"},
+	}
+	proxyState := ledger.ProxyState{
+		Pid:        999,
+		Started:    now,
+		ProxyPaths: map[string]string{"/foo": "localhost:8080", "/bar": "localhost:9000"},
+	}
+
+	buffer := bytes.Buffer{}
+	printJson(buildStatusReport(statuses, states, unmanaged, proxyState, now), &buffer)
+
+	report := jsonStatusReport{}
+	if err := json.Unmarshal(buffer.Bytes(), &report); err != nil {
+		t.Fatalf("output was not valid json: %s\n%s", err, buffer.String())
+	}
+
+	if len(report.Services) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(report.Services))
+	}
+
+	if report.Services[0].Started != nil || report.Services[0].UptimeSeconds != 0 {
+		t.Errorf("MONGO should not have a start time, got %v", report.Services[0].Started)
+	}
+
+	foo := report.Services[1]
+	if foo.Service != "FOO" || foo.Version != "1.2.3" || foo.Pid != 1234 || foo.Port != 8080 || foo.Health != BOOT {
+		t.Errorf("FOO status was not as expected: %+v", foo)
+	}
+	if foo.UptimeSeconds != 90 {
+		t.Errorf("expected uptime of 90 seconds, got %d", foo.UptimeSeconds)
+	}
+	if foo.HealthcheckUrl != "http://localhost:8080/ping/ping" {
+		t.Errorf("unexpected healthcheck url %s", foo.HealthcheckUrl)
+	}
+
+	if len(report.Unmanaged) != 1 || report.Unmanaged[0].ReservedBy != "BAR" || report.Unmanaged[0].Pid != 5555 {
+		t.Errorf("unmanaged ports were not as expected: %+v", report.Unmanaged)
+	}
+
+	if report.ReverseProxy == nil {
+		t.Fatal("expected reverse proxy state to be included")
+	}
+	if len(report.ReverseProxy.Routes) != 2 || report.ReverseProxy.Routes[0].Path != "/bar" {
+		t.Errorf("expected proxy routes to be sorted by path, got %+v", report.ReverseProxy.Routes)
+	}
+}
+
+func TestBuildStatusReportWithoutProxy(t *testing.T) {
+	report := buildStatusReport([]serviceStatus{}, []ledger.StateFile{}, []serviceStatus{}, ledger.ProxyState{}, time.Now())
+
+	if report.ReverseProxy != nil {
+		t.Errorf("reverse proxy should be omitted when it isn't running")
+	}
+
+	buffer := bytes.Buffer{}
+	printJson(report, &buffer)
+	expected := "{\n  \"services\": [],\n  \"unmanaged\": []\n}\n"
+	if buffer.String() != expected {
+		t.Errorf("expected empty lists rather than nulls, got:\n%s", buffer.String())
+	}
+}
+
+func TestBuildVerifyReport(t *testing.T) {
+	report := buildVerifyReport([]verifyResult{{"FOO", true}, {"BAR", false}})
+	if report.Ok {
+		t.Errorf("report should not be ok when a service is missing")
+	}
+	if len(report.Services) != 2 || !report.Services[0].Running || report.Services[1].Running {
+		t.Errorf("unexpected verify results %+v", report.Services)
+	}
+}
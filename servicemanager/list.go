@@ -12,32 +12,57 @@ import (
 )
 
 type portListing struct {
-	port     int
-	service  string
-	frontend bool
+	port        int
+	service     string
+	frontend    bool
+	defaultPort int
 }
 
+// lists the ports of all the services, services that have been started on a different port (e.g. with
+// --auto-port) are shown with the port they're actually using
 func (sm *ServiceManager) ListPorts() {
 	output := []portListing{}
+	started := sm.startedPorts()
 
 	maxLen := 20
 	for _, v := range sm.Services {
 		if len(v.Id) > maxLen {
 			maxLen = len(v.Id)
 		}
-		output = append(output, portListing{v.DefaultPort, v.Id, v.Frontend})
+		port := v.DefaultPort
+		if startedPort, ok := started[v.Id]; ok {
+			port = startedPort
+		}
+		output = append(output, portListing{port, v.Id, v.Frontend, v.DefaultPort})
 	}
 
 	sort.Slice(output, func(i, j int) bool {
 		return output[i].port < output[j].port
 	})
 
+	if sm.formatJson() {
+		ports := []jsonPort{}
+		for _, o := range output {
+			port := jsonPort{Port: o.port, Service: o.service, Frontend: o.frontend}
+			if o.port != o.defaultPort {
+				port.DefaultPort = o.defaultPort
+			}
+			ports = append(ports, port)
+		}
+		printJson(ports, os.Stdout)
+		return
+	}
+
 	frontend := ""
 	for _, o := range output {
 		if o.frontend {
 			frontend = "*"
 		}
-		fmt.Printf("%-5d -> %s  %s\n", o.port, pad(o.service, maxLen), frontend)
+		moved := ""
+		if o.port != o.defaultPort {
+			moved = fmt.Sprintf(" (default %d)", o.defaultPort)
+		}
+		fmt.Printf("%-5d -> %s  %s%s\n", o.port, pad(o.service, maxLen), frontend, moved)
 	}
 }
 
@@ -45,6 +70,13 @@ func (sm *ServiceManager) ListServices(filter string, formatPlain bool) {
 
 	// check if its a profile, list services and exit
 	if profile, ok := sm.Profiles[strings.ToUpper(filter)]; ok {
+		if sm.formatJson() {
+			printJson(jsonSearchResults{
+				Services: []jsonServiceListing{},
+				Profiles: []jsonProfileListing{{Id: strings.ToUpper(filter), Services: profile}},
+			}, os.Stdout)
+			return
+		}
 		fmt.Printf("Profile %s has these services:\n", strings.ToUpper(filter))
 		for _, p := range profile {
 			fmt.Printf("  - %s\n", p)
@@ -53,7 +85,7 @@ func (sm *ServiceManager) ListServices(filter string, formatPlain bool) {
 	}
 
 	// check if its an exact match to a service
-	if service, ok := sm.Services[strings.ToUpper(filter)]; ok {
+	if service, ok := sm.Services[strings.ToUpper(filter)]; ok && !sm.formatJson() {
 		fmt.Println("Found exact match for service:")
 		fmt.Printf("%-25s -> %s\n\n", service.Id, service.Name)
 	}
@@ -88,7 +120,9 @@ func (sm *ServiceManager) ListServices(filter string, formatPlain bool) {
 	}
 	sort.Strings(keys)
 
-	if formatPlain {
+	if sm.formatJson() {
+		sm.printServiceListJson(keys)
+	} else if formatPlain {
 		printServiceListPlain(keys)
 	} else {
 		sm.printServiceListFormatted(keys, search.String(), longestKey)
@@ -110,6 +144,19 @@ func (sm *ServiceManager) printServiceListFormatted(keys []string, searchTerm st
 	}
 }
 
+func (sm *ServiceManager) printServiceListJson(keys []string) {
+	results := jsonSearchResults{Services: []jsonServiceListing{}, Profiles: []jsonProfileListing{}}
+	for _, k := range keys {
+		if service, ok := sm.Services[k]; ok {
+			results.Services = append(results.Services, jsonServiceListing{Id: service.Id, Name: service.Name, Port: service.DefaultPort, Repo: service.Source.Repo})
+		}
+		if profile, ok := sm.Profiles[k]; ok {
+			results.Profiles = append(results.Profiles, jsonProfileListing{Id: k, Services: profile})
+		}
+	}
+	printJson(results, os.Stdout)
+}
+
 func printServiceListPlain(keys []string) {
 	for _, k := range keys {
 		if k != "" {
@@ -131,12 +178,27 @@ func (sm *ServiceManager) ListServicesAvailableOffline() {
 	matches := []ledger.InstallFile{}
 	for _, file := range files {
 		if file.IsDir() {
-			if state, err := sm.Ledger.LoadInstallFile(path.Join(sm.Config.TmpDir, file.Name())); err == nil {
-				matches = append(matches, state)
+			if install, err := sm.Ledger.LoadInstallFile(path.Join(sm.Config.TmpDir, file.Name())); err == nil {
+				matches = append(matches, installedVersions(install)...)
 			}
 		}
 	}
 
+	if sm.formatJson() {
+		installs := []jsonInstall{}
+		for _, install := range matches {
+			installs = append(installs, jsonInstall{
+				Service:   install.Service,
+				Artifact:  install.Artifact,
+				Version:   install.Version,
+				Path:      install.Path,
+				Installed: install.Created,
+			})
+		}
+		printJson(installs, os.Stdout)
+		return
+	}
+
 	if len(matches) == 0 {
 		fmt.Println("No services are installed or are available offline.")
 		return
@@ -146,6 +208,6 @@ func (sm *ServiceManager) ListServicesAvailableOffline() {
 	for _, install := range matches {
 		fmt.Printf(" %-25s %-9s installed\n", install.Service, install.Version)
 	}
-	fmt.Println("You can start them using sm2 --offline --start SERVICE_NAME")
+	fmt.Println("You can start them using sm2 --offline --start SERVICE_NAME (or SERVICE_NAME:VERSION for a specific version)")
 
 }
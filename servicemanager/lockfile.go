@@ -0,0 +1,259 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+)
+
+// A lock file records exactly what's running, so the same environment can be started on another machine:
+//
+//	sm2 --snapshot > env.lock.json
+//	sm2 --start --from-lock env.lock.json
+//
+// Services running from source are recorded as such, but not which commit they were on. Their args are read back out
+// of the sbt start command, which is split on spaces, so an arg that had a space in it comes back as several.
+//
+// Local builds (--from-file) are left out, there's nothing to start them from on another machine.
+
+const (
+	LOCK_MODE_RELEASE = "release"
+	LOCK_MODE_SOURCE  = "source"
+)
+
+type lockFile struct {
+	Created  time.Time       `json:"created"`
+	Services []lockedService `json:"services"`
+}
+
+type lockedService struct {
+	Service  string   `json:"service"`
+	Version  string   `json:"version,omitempty"` // empty when running from source
+	Artifact string   `json:"artifact"`          // including the scala version it was resolved to, e.g. foo_2.13
+	Port     int      `json:"port"`
+	Args     []string `json:"args"`
+	Mode     string   `json:"mode"` // release or source
+}
+
+// args sm2 adds to every service itself (see generateArgs and run), these are added again when it's started from a lock
+var generatedArgPrefixes = []string{
+	"-Dservice.manager.serviceName=",
+	"-Dservice.manager.runFrom=",
+	"-Duser.home=",
+	"-Dhttp.port=",
+}
+
+// prints a lock file of the running services to stdout
+func (sm *ServiceManager) Snapshot() error {
+	lock, err := sm.buildLockFile(time.Now())
+	if err != nil {
+		return err
+	}
+	printJson(lock, os.Stdout)
+	return nil
+}
+
+func (sm *ServiceManager) buildLockFile(now time.Time) (lockFile, error) {
+	lock := lockFile{Created: now, Services: []lockedService{}}
+
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return lock, fmt.Errorf("Unable to read state files in %s: %s", sm.Config.TmpDir, err)
+	}
+	pids := sm.Platform.PidLookup()
+
+	for _, state := range states {
+		if _, ok := pids[state.Pid]; !ok {
+			continue
+		}
+		service, ok := sm.Services[state.Service]
+		if !ok {
+			// stdout is the lock file, so this goes to stderr
+			fmt.Fprintf(os.Stderr, "Skipping %s, it isn't in service-manager-config\n", state.Service)
+			continue
+		}
+
+		install, installed := sm.installedVersion(state)
+		if install.Local {
+			fmt.Fprintf(os.Stderr, "Skipping %s, it's running a local build (--from-file) that can't be started anywhere else\n", state.Service)
+			continue
+		}
+		artifact := state.Artifact
+		if installed && install.Artifact != "" {
+			artifact = install.Artifact
+		}
+
+		locked := lockedService{
+			Service:  state.Service,
+			Version:  state.Version,
+			Artifact: artifact,
+			Port:     state.Port,
+			Args:     lockedArgs(service, state),
+			Mode:     LOCK_MODE_RELEASE,
+		}
+		if state.Version == SOURCE {
+			locked.Version = ""
+			locked.Mode = LOCK_MODE_SOURCE
+		}
+		lock.Services = append(lock.Services, locked)
+	}
+
+	sort.Slice(lock.Services, func(i, j int) bool {
+		return lock.Services[i].Service < lock.Services[j].Service
+	})
+	return lock, nil
+}
+
+// The install a service is running from. This has the artifact it was actually installed from, since the state
+// file only has the one from the config, which might be foo_%% rather than the scala version that was picked.
+func (sm *ServiceManager) installedVersion(state ledger.StateFile) (ledger.InstallFile, bool) {
+	installDir, err := sm.findInstallDirOfService(state.Service)
+	if err != nil {
+		return ledger.InstallFile{}, false
+	}
+	install, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		return ledger.InstallFile{}, false
+	}
+	for _, v := range installedVersions(install) {
+		if v.Path == state.Path {
+			return v, true
+		}
+	}
+	return ledger.InstallFile{}, false
+}
+
+// The args a service was started with, minus the ones that come from its config or that sm2 adds itself, since
+// they'll be added again when it's started from the lock file.
+func lockedArgs(service Service, state ledger.StateFile) []string {
+	args := state.Args
+	generated := []string{}
+	if len(service.Binary.Cmd) > 1 {
+		generated = append(generated, service.Binary.Cmd[1:]...)
+	}
+
+	if state.Version == SOURCE {
+		// sbt is run with a single start command holding all the args (see sbtBuildAndRun)
+		if len(args) == 0 {
+			return []string{}
+		}
+		args = strings.Fields(args[len(args)-1])
+		generated = append(append(generated, "start", "start"), service.Source.ExtraParams...)
+	}
+
+	skip := map[string]int{}
+	for _, arg := range generated {
+		skip[arg]++
+	}
+
+	result := []string{}
+	for _, arg := range args {
+		if skip[arg] > 0 {
+			skip[arg]--
+			continue
+		}
+		if isGeneratedArg(arg) {
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+func isGeneratedArg(arg string) bool {
+	for _, prefix := range generatedArgPrefixes {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadLockFile(file string) (lockFile, error) {
+	lock := lockFile{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return lock, fmt.Errorf("Unable to read lock file %s: %s", file, err)
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return lock, fmt.Errorf("Unable to read lock file %s: %s", file, err)
+	}
+	if len(lock.Services) == 0 {
+		return lock, fmt.Errorf("Lock file %s doesn't have any services in it", file)
+	}
+	return lock, nil
+}
+
+// Loads the services to start from --from-lock, checking they match service-manager-config. Their args are added to
+// any from --appendArgs.
+func (sm *ServiceManager) applyLockFile() ([]ServiceAndVersion, error) {
+	file := sm.Commands.FromLock
+	if len(sm.Commands.ExtraServices) > 0 {
+		return nil, fmt.Errorf("--from-lock can't be used with other services or profiles, they all come from %s", file)
+	}
+	if sm.Commands.FromSource || sm.Commands.FromFile != "" || sm.Commands.Release != "" {
+		return nil, fmt.Errorf("--from-lock can't be used with --src, --from-file or -r")
+	}
+
+	lock, err := loadLockFile(file)
+	if err != nil {
+		return nil, err
+	}
+	services, args, err := sm.lockedServices(lock, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if sm.Commands.ExtraArgs == nil {
+		sm.Commands.ExtraArgs = map[string][]string{}
+	}
+	for id, serviceArgs := range args {
+		// anything from --appendArgs goes after the args in the lock file
+		sm.Commands.ExtraArgs[id] = append(serviceArgs, sm.Commands.ExtraArgs[id]...)
+	}
+	return services, nil
+}
+
+// the services and args for everything in a lock file, checking it matches service-manager-config
+func (sm *ServiceManager) lockedServices(lock lockFile, file string) ([]ServiceAndVersion, map[string][]string, error) {
+	services := []ServiceAndVersion{}
+	args := map[string][]string{}
+
+	for _, locked := range lock.Services {
+		service, ok := sm.Services[locked.Service]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s in %s isn't in service-manager-config", locked.Service, file)
+		}
+
+		configArtifact := scalaSuffix.ReplaceAllLiteralString(service.Binary.Artifact, "")
+		if artifact := scalaSuffix.ReplaceAllLiteralString(locked.Artifact, ""); locked.Artifact != "" && artifact != configArtifact {
+			return nil, nil, fmt.Errorf("%s in %s is %s, but service-manager-config has %s", locked.Service, file, locked.Artifact, service.Binary.Artifact)
+		}
+
+		sv := ServiceAndVersion{locked.Service, locked.Version, "", locked.Port, false}
+		switch locked.Mode {
+		case LOCK_MODE_SOURCE:
+			sv.version, sv.fromSource = "", true
+		case LOCK_MODE_RELEASE, "":
+			if sv.version == "" || isVersionConstraint(sv.version) {
+				return nil, nil, fmt.Errorf("%s in %s must have an exact version, got %q", locked.Service, file, sv.version)
+			}
+		default:
+			return nil, nil, fmt.Errorf("%s in %s has an unknown mode %s, expected release or source", locked.Service, file, locked.Mode)
+		}
+		if matches := scalaSuffix.FindStringSubmatch(locked.Artifact); matches != nil && matches[1] != "%%" {
+			sv.scalaVersion = matches[1]
+		}
+		services = append(services, sv)
+
+		if len(locked.Args) > 0 {
+			args[locked.Service] = locked.Args
+		}
+	}
+	return services, args, nil
+}
@@ -0,0 +1,215 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+var lockServices = map[string]Service{
+	"FOO": {
+		Id:     "FOO",
+		Binary: ServiceBinary{Artifact: "foo_%%", DestinationSubdir: "foo", Cmd: []string{"./foo/bin/foo", "-J-Xmx256m"}},
+	},
+	"BAR": {
+		Id:     "BAR",
+		Binary: ServiceBinary{Artifact: "bar", DestinationSubdir: "bar", Cmd: []string{"./bar/bin/bar"}},
+		Source: Source{ExtraParams: []string{"-Drun.mode=Dev"}},
+	},
+}
+
+// FOO 1.2.3 (installed as foo_2.13) and BAR from source are running, BAZ has stopped
+func snapshotServiceManager(t *testing.T) *ServiceManager {
+	tmpDir := t.TempDir()
+	fooPath := path.Join(tmpDir, "foo", "1.2.3", "foo-1.2.3")
+
+	states := []ledger.StateFile{
+		{
+			Service:  "FOO",
+			Artifact: "foo_%%",
+			Version:  "1.2.3",
+			Path:     fooPath,
+			Pid:      9999,
+			Port:     9001,
+			Args: []string{
+				"-J-Xmx256m",
+				"-Dservice.manager.serviceName=FOO",
+				"-Dservice.manager.runFrom=1.2.3",
+				"-Duser.home=" + path.Join(tmpDir, "foo", "1.2.3"),
+				"-Dmicroservice.services.bar.port=9002",
+				"-Dfeature.enabled=true",
+				"-Dhttp.port=9001",
+			},
+		},
+		{
+			Service:  "BAR",
+			Artifact: "bar",
+			Version:  SOURCE,
+			Path:     path.Join(tmpDir, "bar", "bar"),
+			Pid:      7777,
+			Port:     9002,
+			Args:     []string{"-mem", "2048", "start start -Dhttp.port=9002  -Drun.mode=Dev -Dservice.manager.serviceName=BAR -Dservice.manager.runFrom=src -Duser.home=/tmp -Dbar=1"},
+		},
+		{Service: "BAZ", Version: "1.0.0", Pid: 6666, Port: 9003},
+	}
+
+	return &ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: tmpDir},
+		Services: lockServices,
+		Platform: platform.Platform{PidLookup: mockPidLookup},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(_ string) ([]ledger.StateFile, error) {
+				return states, nil
+			},
+			LoadInstallFile: func(dir string) (ledger.InstallFile, error) {
+				install := ledger.InstallFile{Service: "FOO", Artifact: "foo_2.13", Version: "1.2.3", Path: fooPath}
+				install.Versions = []ledger.InstallFile{install, {Service: "FOO", Artifact: "foo_2.12", Version: "1.0.0", Path: "/elsewhere"}}
+				return install, nil
+			},
+		},
+	}
+}
+
+func TestSnapshotRecordsRunningServices(t *testing.T) {
+	sm := snapshotServiceManager(t)
+	now := time.Now()
+
+	lock, err := sm.buildLockFile(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []lockedService{
+		{Service: "BAR", Artifact: "bar", Port: 9002, Args: []string{"-Dbar=1"}, Mode: LOCK_MODE_SOURCE},
+		{
+			Service:  "FOO",
+			Version:  "1.2.3",
+			Artifact: "foo_2.13",
+			Port:     9001,
+			Args:     []string{"-Dmicroservice.services.bar.port=9002", "-Dfeature.enabled=true"},
+			Mode:     LOCK_MODE_RELEASE,
+		},
+	}
+	if !lock.Created.Equal(now) {
+		t.Errorf("expected the lock to be created at %s, got %s", now, lock.Created)
+	}
+	if !reflect.DeepEqual(lock.Services, expected) {
+		t.Errorf("expected the running services to be locked\n got: %+v\nwant: %+v", lock.Services, expected)
+	}
+}
+
+func writeLockFile(t *testing.T, lock lockFile) string {
+	t.Helper()
+	data, err := json.Marshal(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(t.TempDir(), "env.lock.json")
+	os.WriteFile(file, data, 0644)
+	return file
+}
+
+func TestStartFromLockFile(t *testing.T) {
+	snapshot := snapshotServiceManager(t)
+	lock, _ := snapshot.buildLockFile(time.Now())
+
+	sm := ServiceManager{
+		Services: lockServices,
+		Commands: cli.UserOption{
+			Start:     true,
+			FromLock:  writeLockFile(t, lock),
+			ExtraArgs: map[string][]string{"FOO": {"-Dextra=1"}},
+		},
+	}
+	services, err := sm.applyLockFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ServiceAndVersion{
+		{"BAR", "", "", 9002, true},
+		{"FOO", "1.2.3", "2.13", 9001, false},
+	}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected the services in the lock file to be started, got %v", services)
+	}
+
+	if args := sm.Commands.ExtraArgs["FOO"]; !reflect.DeepEqual(args, []string{"-Dmicroservice.services.bar.port=9002", "-Dfeature.enabled=true", "-Dextra=1"}) {
+		t.Errorf("expected FOO's args from the lock file followed by --appendArgs, got %v", args)
+	}
+	if args := sm.Commands.ExtraArgs["BAR"]; !reflect.DeepEqual(args, []string{"-Dbar=1"}) {
+		t.Errorf("expected BAR's args from the lock file, got %v", args)
+	}
+
+	// the args sm2 generates are the same as when the snapshot was taken
+	args := sm.generateArgs(sm.Services["FOO"], "1.2.3", "/tmp/foo/1.2.3/foo-1.2.3", sm.Services["FOO"].Binary.Cmd[1:])
+	if strings.Count(strings.Join(args, " "), "-Dfeature.enabled=true") != 1 || args[0] != "-J-Xmx256m" {
+		t.Errorf("expected FOO's args to be recreated, got %v", args)
+	}
+}
+
+func TestSourceIsNotAVersion(t *testing.T) {
+	// only a lock file can say a service runs from source, FOO:source is just a version that doesn't exist
+	if sv := parseServiceAndVersion("FOO:source"); sv.fromSource || sv.version != SOURCE {
+		t.Errorf("expected FOO:source not to run from source, got %+v", sv)
+	}
+}
+
+func TestFromLockFileErrors(t *testing.T) {
+	valid := lockedService{Service: "FOO", Version: "1.2.3", Artifact: "foo_2.13", Port: 9001, Mode: LOCK_MODE_RELEASE}
+	with := func(change func(*lockedService)) lockFile {
+		locked := valid
+		change(&locked)
+		return lockFile{Services: []lockedService{locked}}
+	}
+
+	tests := map[string]struct {
+		lock     lockFile
+		commands cli.UserOption
+		err      string
+	}{
+		"other services":     {lock: with(func(l *lockedService) {}), commands: cli.UserOption{Start: true, ExtraServices: []string{"BAR"}}, err: "can't be used with other services"},
+		"from source":        {lock: with(func(l *lockedService) {}), commands: cli.UserOption{Start: true, FromSource: true}, err: "can't be used with --src"},
+		"no services":        {lock: lockFile{}, commands: cli.UserOption{Start: true}, err: "doesn't have any services"},
+		"unknown service":    {lock: with(func(l *lockedService) { l.Service = "NOPE" }), commands: cli.UserOption{Start: true}, err: "NOPE in"},
+		"different artifact": {lock: with(func(l *lockedService) { l.Artifact = "bar_2.13" }), commands: cli.UserOption{Start: true}, err: "service-manager-config has foo_%%"},
+		"no version":         {lock: with(func(l *lockedService) { l.Version = "" }), commands: cli.UserOption{Start: true}, err: "must have an exact version"},
+		"version range":      {lock: with(func(l *lockedService) { l.Version = "^1.2" }), commands: cli.UserOption{Start: true}, err: "must have an exact version"},
+		"unknown mode":       {lock: with(func(l *lockedService) { l.Mode = "docker" }), commands: cli.UserOption{Start: true}, err: "unknown mode docker"},
+	}
+
+	for name, test := range tests {
+		sm := ServiceManager{Services: lockServices, Commands: test.commands}
+		sm.Commands.FromLock = writeLockFile(t, test.lock)
+		if _, err := sm.applyLockFile(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, test.err, err)
+		}
+	}
+}
+
+func TestSnapshotSkipsLocalBuilds(t *testing.T) {
+	sm := snapshotServiceManager(t)
+	loadInstallFile := sm.Ledger.LoadInstallFile
+	sm.Ledger.LoadInstallFile = func(dir string) (ledger.InstallFile, error) {
+		// FOO 1.2.3 was started with --from-file
+		install, err := loadInstallFile(dir)
+		install.Versions[0].Local = true
+		return install, err
+	}
+
+	lock, err := sm.buildLockFile(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Services) != 1 || lock.Services[0].Service != "BAR" {
+		t.Errorf("expected FOO's local build to be left out, got %+v", lock.Services)
+	}
+}
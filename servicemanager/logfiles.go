@@ -1,56 +1,339 @@
 package servicemanager
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
 )
 
-// clears exists logs and creates the folder if its missing
+// creates the log folder if its missing, existing logs are kept so they can be rotated when the service starts
 func initLogDir(serviceDir string) (string, error) {
 	logPath := path.Join(serviceDir, "logs")
+	return logPath, os.MkdirAll(logPath, 0755)
+}
+
+// filters applied to logs by --lines, --grep and --since
+type logOptions struct {
+	lines int            // only show the last n lines, 0 shows everything
+	grep  *regexp.Regexp // only show matching lines
+	since time.Time      // only show lines logged after this time
+}
+
+var logTimestamp = regexp.MustCompile(`^\s*(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2})`)
+
+var logColours = []int{36, 33, 32, 35, 34, 31}
+
+// builds the log filters from the cli options
+func (sm *ServiceManager) logOptions(now time.Time) (logOptions, error) {
+	opts := logOptions{lines: sm.Commands.Lines}
 
-	// if logdir exists remove it
-	if _, err := os.Stat(logPath); os.IsExist(err) {
-		rmErr := os.RemoveAll(logPath)
-		if rmErr != nil {
-			return logPath, rmErr
+	if sm.Commands.Grep != "" {
+		rx, err := regexp.Compile(sm.Commands.Grep)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --grep: %s", err)
 		}
+		opts.grep = rx
 	}
-	return logPath, os.MkdirAll(logPath, 0755)
+
+	if sm.Commands.Since != "" {
+		since, err := parseSince(sm.Commands.Since, now)
+		if err != nil {
+			return opts, err
+		}
+		opts.since = since
+	}
+	return opts, nil
 }
 
-func (sm *ServiceManager) PrintLogsForService(serviceName string) {
+// --since can be either a duration (e.g. 10m) or a time (e.g. 2024-01-02T15:04:05 or 15:04)
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %s, expected a duration (e.g. 10m) or a time (e.g. 2006-01-02T15:04:05)", since)
+}
+
+func (opts logOptions) isFiltered() bool {
+	return opts.lines > 0 || opts.grep != nil || !opts.since.IsZero()
+}
+
+// Applies the filters to a log file as its read. Lines without a timestamp (e.g. stack traces)
+// are kept or dropped along with the line before them. Until a timestamp has been seen --since can't
+// be applied, so lines are kept, otherwise a log in another format (e.g. json) would show nothing.
+type logFilter struct {
+	opts          logOptions
+	after         bool
+	seenTimestamp bool
+}
+
+func (f *logFilter) include(line string) bool {
+	if !f.opts.since.IsZero() {
+		if m := logTimestamp.FindStringSubmatch(line); m != nil {
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", m[1]+" "+m[2], time.Local); err == nil {
+				f.after = !t.Before(f.opts.since)
+				f.seenTimestamp = true
+			}
+		}
+		if f.seenTimestamp && !f.after {
+			return false
+		}
+	}
+	return f.opts.grep == nil || f.opts.grep.MatchString(line)
+}
+
+// finds the stdout.log of a service
+func (sm *ServiceManager) logPathForService(serviceName string) (string, error) {
 
 	installDir, err := sm.findInstallDirOfService(serviceName)
 	if err != nil {
-		fmt.Printf("Couldn't find the logs for %s\n", serviceName)
-		return
+		return "", fmt.Errorf("Couldn't find the logs for %s", serviceName)
 	}
 
 	installFile, err := sm.Ledger.LoadInstallFile(installDir)
 	if err != nil {
-		fmt.Printf("Unable to find installation of service in %s\n\t%s", installDir, err)
-		return
+		return "", fmt.Errorf("Unable to find installation of service in %s\n\t%s", installDir, err)
 	}
 
 	logDir := path.Join(installFile.Path, "logs")
 
 	if !Exists(logDir) {
-		fmt.Printf("Couldn't find the logs for %s\n", serviceName)
-		return
+		return "", fmt.Errorf("Couldn't find the logs for %s", serviceName)
 	}
 
 	pathToLog := path.Join(logDir, "stdout.log")
+	if sm.Commands.Previous > 0 {
+		pathToLog = archivedLogPath(pathToLog, sm.Commands.Previous)
+		if !Exists(pathToLog) {
+			return "", fmt.Errorf("There are only %d previous logs for %s", countArchivedLogs(path.Join(logDir, "stdout.log")), serviceName)
+		}
+	}
+	return pathToLog, nil
+}
+
+// how many old logs have been kept for a service
+func countArchivedLogs(logPath string) int {
+	n := 0
+	for Exists(archivedLogPath(logPath, n+1)) {
+		n++
+	}
+	return n
+}
+
+// copies the stdout.log of a service to the given writer
+func (sm *ServiceManager) writeLogsForService(serviceName string, opts logOptions, out io.Writer) error {
+	pathToLog, err := sm.logPathForService(serviceName)
+	if err != nil {
+		return err
+	}
+	_, err = writeLog(pathToLog, opts, "", out)
+	return err
+}
+
+// writes a log file, with each line prefixed, returning how much of the file was read
+func writeLog(pathToLog string, opts logOptions, prefix string, out io.Writer) (int64, error) {
 
 	file, err := os.Open(pathToLog)
 	if err != nil {
-		fmt.Printf("Failed to open logfile %s: %s\n", pathToLog, err)
-		return
+		return 0, fmt.Errorf("Failed to open logfile %s: %s", pathToLog, err)
 	}
 
 	defer file.Close()
 
-	io.Copy(os.Stdout, file)
+	// nothing to do, just copy it as is
+	if !opts.isFiltered() && prefix == "" {
+		return io.Copy(out, file)
+	}
+
+	filter := logFilter{opts: opts}
+	reader := bufio.NewReader(file)
+	var read int64
+	tail := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		read += int64(len(line))
+		if line != "" && filter.include(strings.TrimRight(line, "\n")) {
+			if opts.lines > 0 {
+				tail = append(tail, line)
+				if len(tail) > opts.lines {
+					tail = tail[1:]
+				}
+			} else {
+				writeLogLine(out, prefix, line)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return read, err
+		}
+	}
+
+	for _, line := range tail {
+		writeLogLine(out, prefix, line)
+	}
+	if !opts.since.IsZero() && !filter.seenTimestamp {
+		fmt.Fprintf(os.Stderr, "Warning: %s has no timestamps like 2006-01-02 15:04:05, so --since wasn't applied\n", pathToLog)
+	}
+	return read, nil
+}
+
+func writeLogLine(out io.Writer, prefix string, line string) {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	fmt.Fprint(out, prefix+line)
+}
+
+// the services to show the logs of, with any profiles expanded
+func (sm *ServiceManager) logServices() []string {
+	services := []string{}
+	seen := map[string]bool{}
+	for _, s := range append([]string{sm.Commands.Logs}, sm.Commands.ExtraServices...) {
+		names := []string{s}
+		if profile, ok := sm.Profiles[strings.ToUpper(s)]; ok {
+			names = profileServiceIds(profile)
+		} else if _, ok := sm.Services[strings.ToUpper(s)]; ok {
+			names = []string{strings.ToUpper(s)}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				services = append(services, name)
+				seen[name] = true
+			}
+		}
+	}
+	return services
+}
+
+// builds the `SERVICE | ` prefixes used when showing the logs of more than one service
+func logPrefixes(services []string, colour bool) []string {
+	width := 0
+	for _, s := range services {
+		width = max(width, len(s))
+	}
+
+	prefixes := []string{}
+	for i, s := range services {
+		prefix := fmt.Sprintf("%-*s | ", width, s)
+		if colour {
+			prefix = fmt.Sprintf("\033[%dm%s\033[0m", logColours[i%len(logColours)], prefix)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// Prints the logs of one or more services (or profiles). When there's more than one service each line is prefixed
+// with the name of the service, and with --follow new lines are interleaved as they're written.
+func (sm *ServiceManager) PrintLogs() {
+
+	opts, err := sm.logOptions(time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	requested := sm.logServices()
+	services := []string{}
+	paths := []string{}
+	for _, service := range requested {
+		pathToLog, err := sm.logPathForService(service)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		services = append(services, service)
+		paths = append(paths, pathToLog)
+	}
+
+	prefixes := make([]string, len(services))
+	if len(requested) > 1 {
+		prefixes = logPrefixes(services, !sm.Commands.FormatPlain)
+	}
+
+	offsets := make([]int64, len(services))
+	for i := range services {
+		offsets[i], err = writeLog(paths[i], opts, prefixes[i], os.Stdout)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// old logs aren't going to change
+	if !sm.Commands.Follow || sm.Commands.Previous > 0 || len(services) == 0 {
+		return
+	}
+
+	lines := make(chan string)
+	for i := range services {
+		go followLog(paths[i], offsets[i], logFilter{opts: logOptions{grep: opts.grep}, after: true}, prefixes[i], lines)
+	}
+	for line := range lines {
+		fmt.Print(line)
+	}
+}
+
+// Watches a log file for new lines, sending them (prefixed) to the channel. If the file is
+// truncated or replaced (e.g. the service is restarted) it starts again from the beginning.
+func followLog(pathToLog string, offset int64, filter logFilter, prefix string, lines chan<- string) {
+	partial := ""
+	last, _ := os.Stat(pathToLog)
+	for {
+		time.Sleep(250 * time.Millisecond)
+
+		info, err := os.Stat(pathToLog)
+		if err != nil {
+			continue
+		}
+		if info.Size() < offset || (last != nil && !os.SameFile(last, info)) {
+			offset = 0
+			partial = ""
+		}
+		last = info
+		if info.Size() == offset {
+			continue
+		}
+
+		file, err := os.Open(pathToLog)
+		if err != nil {
+			continue
+		}
+		file.Seek(offset, io.SeekStart)
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		offset += int64(len(content))
+
+		chunk := partial + string(content)
+		end := strings.LastIndex(chunk, "\n")
+		partial = chunk[end+1:]
+		if end < 0 {
+			continue
+		}
+		for _, line := range strings.Split(chunk[:end], "\n") {
+			if filter.include(line) {
+				lines <- prefix + line + "\n"
+			}
+		}
+	}
 }
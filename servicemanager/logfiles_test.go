@@ -0,0 +1,170 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+)
+
+const testLog = `2024-03-01 10:00:00,000 level=[INFO] starting
+2024-03-01 10:05:00,000 level=[ERROR] something failed
+	at uk.gov.hmrc.Foo.bar(Foo.scala:10)
+2024-03-01 10:10:00,000 level=[INFO] request GET /ping
+2024-03-01 10:15:00,000 level=[INFO] request GET /ping
+`
+
+func writeTestLog(t *testing.T) string {
+	logFile := path.Join(t.TempDir(), "stdout.log")
+	if err := os.WriteFile(logFile, []byte(testLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return logFile
+}
+
+func TestWriteLogUnfiltered(t *testing.T) {
+	out := bytes.Buffer{}
+	read, err := writeLog(writeTestLog(t), logOptions{}, "", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != testLog || read != int64(len(testLog)) {
+		t.Errorf("expected the log to be copied as is, got:\n%s", out.String())
+	}
+}
+
+func TestWriteLogFilters(t *testing.T) {
+	since := time.Date(2024, 3, 1, 10, 5, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		opts     logOptions
+		prefix   string
+		expected []string
+	}{
+		{"lines", logOptions{lines: 2}, "", []string{"10:10:00", "10:15:00"}},
+		{"grep", logOptions{grep: regexp.MustCompile("ERROR")}, "", []string{"10:05:00"}},
+		{"since", logOptions{since: since}, "", []string{"10:05:00", "Foo.scala", "10:10:00", "10:15:00"}},
+		{"since and lines", logOptions{since: since, lines: 3}, "", []string{"Foo.scala", "10:10:00", "10:15:00"}},
+		{"prefix", logOptions{grep: regexp.MustCompile("starting")}, "FOO | ", []string{"FOO | 2024-03-01 10:00:00"}},
+	}
+
+	for _, test := range tests {
+		out := bytes.Buffer{}
+		if _, err := writeLog(writeTestLog(t), test.opts, test.prefix, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != len(test.expected) {
+			t.Errorf("%s: expected %d lines, got:\n%s", test.name, len(test.expected), out.String())
+			continue
+		}
+		for i, expected := range test.expected {
+			if !strings.Contains(lines[i], expected) {
+				t.Errorf("%s: expected line %d to contain %q, got %q", test.name, i, expected, lines[i])
+			}
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		since    string
+		expected time.Time
+	}{
+		{"10m", time.Date(2024, 3, 1, 11, 50, 0, 0, time.Local)},
+		{"2024-02-28T09:30:00", time.Date(2024, 2, 28, 9, 30, 0, 0, time.Local)},
+		{"2024-02-28", time.Date(2024, 2, 28, 0, 0, 0, 0, time.Local)},
+		{"09:30", time.Date(2024, 3, 1, 9, 30, 0, 0, time.Local)},
+	}
+
+	for _, test := range tests {
+		got, err := parseSince(test.since, now)
+		if err != nil {
+			t.Errorf("parseSince(%s) failed: %s", test.since, err)
+		} else if !got.Equal(test.expected) {
+			t.Errorf("parseSince(%s) = %v, expected %v", test.since, got, test.expected)
+		}
+	}
+
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Errorf("expected an error for an invalid --since")
+	}
+}
+
+func TestWriteLogSinceWithoutTimestamps(t *testing.T) {
+	logFile := path.Join(t.TempDir(), "stdout.log")
+	os.WriteFile(logFile, []byte(`{"level":"INFO","message":"starting"}`+"\n"+`{"level":"INFO","message":"started"}`+"\n"), 0644)
+
+	out := bytes.Buffer{}
+	if _, err := writeLog(logFile, logOptions{since: time.Now().Add(-time.Minute)}, "", &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("expected every line of a log without timestamps, got:\n%s", out.String())
+	}
+}
+
+func TestLogServicesIgnoresCase(t *testing.T) {
+	sm := ServiceManager{
+		Services: map[string]Service{"C": {Id: "C"}},
+		Profiles: map[string][]string{"PROFILE": {"A", "B"}},
+		Commands: cli.UserOption{Logs: "profile", ExtraServices: []string{"c"}},
+	}
+
+	if got := strings.Join(sm.logServices(), ","); got != "A,B,C" {
+		t.Errorf("expected A,B,C got %s", got)
+	}
+}
+
+func TestLogServicesExpandsProfiles(t *testing.T) {
+	sm := ServiceManager{
+		Profiles: map[string][]string{"PROFILE": {"A", "B"}},
+		Commands: cli.UserOption{Logs: "B", ExtraServices: []string{"PROFILE", "C"}},
+	}
+
+	if got := strings.Join(sm.logServices(), ","); got != "B,A,C" {
+		t.Errorf("expected B,A,C got %s", got)
+	}
+}
+
+func TestLogPrefixes(t *testing.T) {
+	prefixes := logPrefixes([]string{"A", "LONGER"}, false)
+	if prefixes[0] != "A      | " || prefixes[1] != "LONGER | " {
+		t.Errorf("expected the prefixes to be aligned, got %q", prefixes)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	logFile := writeTestLog(t)
+	lines := make(chan string)
+
+	go followLog(logFile, int64(len(testLog)), logFilter{after: true}, "FOO | ", lines)
+
+	f, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new line\npartial")
+	f.Close()
+
+	select {
+	case line := <-lines:
+		if line != "FOO | new line\n" {
+			t.Errorf("unexpected line %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the new line")
+	}
+
+	select {
+	case line := <-lines:
+		t.Errorf("expected partial lines to be held back, got %q", line)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,146 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+)
+
+const (
+	LOG_MAX_SIZE = 10 * 1024 * 1024 // stdout.log is rotated once it gets this big
+	LOG_KEEP     = 5                // how many old logs to keep (stdout.log.1 ... stdout.log.5)
+)
+
+// A log file that's rotated when it gets too large. Old logs are kept as stdout.log.1, stdout.log.2 etc,
+// with .1 being the most recent.
+type rotatingLog struct {
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64
+	keep    int
+}
+
+// opens a log file, appending to it if it already exists
+func openRotatingLog(logPath string, maxSize int64, keep int) (*rotatingLog, error) {
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return &rotatingLog{path: logPath, file: file, size: size, maxSize: maxSize, keep: keep}, nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+	l.file.Close()
+	if err := rotateLogs(l.path, l.keep); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+func (l *rotatingLog) Close() error {
+	return l.file.Close()
+}
+
+// Moves a log to .1, .1 to .2 and so on, deleting the oldest. Does nothing if the log doesn't exist.
+func rotateLogs(logPath string, keep int) error {
+	if !Exists(logPath) {
+		return nil
+	}
+
+	os.Remove(archivedLogPath(logPath, keep))
+	for i := keep - 1; i >= 1; i-- {
+		if Exists(archivedLogPath(logPath, i)) {
+			if err := os.Rename(archivedLogPath(logPath, i), archivedLogPath(logPath, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if keep < 1 {
+		return os.Remove(logPath)
+	}
+	return os.Rename(logPath, archivedLogPath(logPath, 1))
+}
+
+func archivedLogPath(logPath string, n int) string {
+	return fmt.Sprintf("%s.%d", logPath, n)
+}
+
+// Copies everything from in to a log file until in is closed, rotating the log when it gets too large.
+// sm2 runs itself with --write-log to do this, so logs keep being written after sm2 exits.
+func WriteLog(in io.Reader, logPath string) error {
+	log, err := openRotatingLog(logPath, LOG_MAX_SIZE, LOG_KEEP)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	_, err = io.Copy(log, in)
+	return err
+}
+
+// Archives the last stdout.log in the given log dir and starts an sm2 --write-log process to write the next one.
+// The returned file should be used as the stdout/stderr of the service, and closed once the service has started.
+func startLogWriter(logDir string) (*os.File, error) {
+	logPath := path.Join(logDir, "stdout.log")
+
+	// the new log needs to exist before we return, otherwise anything reading it straight away will get the old one
+	if err := rotateLogs(logPath, LOG_KEEP); err != nil {
+		return nil, fmt.Errorf("unable to rotate %s: %s", logPath, err)
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	logFile.Close()
+
+	sm2, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// the writer gets its own process group so stopping the service (or ctrl-c) doesn't stop it before the
+	// service has finished writing, it exits by itself once the service (and anything it started) has exited.
+	cmd := exec.Command(sm2, "--write-log", logPath)
+	cmd.Stdin = reader
+	cmd.SysProcAttr = newProcessGroup()
+	if err := cmd.Start(); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("unable to start log writer: %s", err)
+	}
+	waitForExit(cmd)
+
+	return writer, nil
+}
@@ -0,0 +1,74 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func readTestFile(t *testing.T, file string) string {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", file, err)
+	}
+	return string(content)
+}
+
+func TestRotateLogs(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "stdout.log")
+
+	for _, run := range []string{"first", "second", "third", "fourth"} {
+		if err := rotateLogs(logPath, 2); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(logPath, []byte(run), 0644)
+	}
+
+	if got := readTestFile(t, logPath); got != "fourth" {
+		t.Errorf("expected the current log to be fourth, got %s", got)
+	}
+	if got := readTestFile(t, logPath+".1"); got != "third" {
+		t.Errorf("expected .1 to be third, got %s", got)
+	}
+	if got := readTestFile(t, logPath+".2"); got != "second" {
+		t.Errorf("expected .2 to be second, got %s", got)
+	}
+	if Exists(logPath + ".3") {
+		t.Errorf("expected only 2 old logs to be kept")
+	}
+	if n := countArchivedLogs(logPath); n != 2 {
+		t.Errorf("expected 2 archived logs, got %d", n)
+	}
+}
+
+func TestRotatingLogRotatesBySize(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "stdout.log")
+	os.WriteFile(logPath, []byte("existing\n"), 0644)
+
+	log, err := openRotatingLog(logPath, 20, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Write([]byte("line 1\n"))
+	log.Write([]byte("line 2\n"))
+	log.Write([]byte("line 3\n"))
+	log.Close()
+
+	if got := readTestFile(t, logPath+".1"); got != "existing\nline 1\n" {
+		t.Errorf("expected the log to be appended to before rotating, got %q", got)
+	}
+	if got := readTestFile(t, logPath); got != "line 2\nline 3\n" {
+		t.Errorf("unexpected current log %q", got)
+	}
+}
+
+func TestWriteLog(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "stdout.log")
+	if err := WriteLog(strings.NewReader("hello\nworld\n"), logPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, logPath); got != "hello\nworld\n" {
+		t.Errorf("unexpected log %q", got)
+	}
+}
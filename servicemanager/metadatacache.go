@@ -0,0 +1,87 @@
+package servicemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DEFAULT_METADATA_TTL = 300
+
+// The maven metadata we've fetched, so starting a big profile doesn't mean looking up every service (and every scala
+// version of it) again. Once it's older than MetadataTtl it's checked with artifactory using its ETag/Last-Modified.
+// It's also what --offline uses to work out the latest known version of a service.
+type cachedMetadata struct {
+	Url          string
+	Fetched      time.Time
+	ETag         string
+	LastModified string
+	NotFound     bool // the repo doesn't have it
+	Metadata     MavenMetadata
+}
+
+// where the metadata for a url is cached, or "" if it isn't. local repos aren't cached since they're quick to read
+// and change every time something's published to them
+func (sm *ServiceManager) metadataCacheFile(url string) string {
+	if sm.Config.CacheDir == "" || isFileRepo(url) {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(sm.Config.CacheDir, "metadata", hex.EncodeToString(sum[:])+".json")
+}
+
+func loadCachedMetadata(cacheFile string) (cachedMetadata, bool) {
+	cached := cachedMetadata{}
+	if cacheFile == "" {
+		return cached, false
+	}
+	data, err := os.ReadFile(cacheFile)
+	if err != nil || json.Unmarshal(data, &cached) != nil {
+		return cachedMetadata{}, false
+	}
+	return cached, true
+}
+
+// the cache is only there to speed things up, so failing to write to it isn't an error
+func saveCachedMetadata(cacheFile string, cached cachedMetadata) {
+	if cacheFile == "" {
+		return
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return
+	}
+	// written to a temp file first so another sm2 never reads half of it
+	tmp, err := os.CreateTemp(filepath.Dir(cacheFile), ".metadata-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err == nil {
+		os.Rename(tmp.Name(), cacheFile)
+	}
+}
+
+// whether cached metadata can be used without checking it with the repo first
+func (sm *ServiceManager) isFresh(cached cachedMetadata) bool {
+	if sm.Commands.Offline {
+		return true
+	}
+	return !sm.Commands.Clean && time.Since(cached.Fetched) < sm.Config.MetadataTtl
+}
+
+func (c cachedMetadata) result() (MavenMetadata, error) {
+	if c.NotFound {
+		return MavenMetadata{}, fmt.Errorf("failed to find maven-metadata.xml at %s: %w", c.Url, errNotInRepo)
+	}
+	return c.Metadata, nil
+}
@@ -0,0 +1,152 @@
+package servicemanager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a repo that has foo with an ETag, counting the requests made and how many of them were revalidations
+type metadataRepo struct {
+	*httptest.Server
+	requests    atomic.Int32
+	revalidated atomic.Int32
+}
+
+func newMetadataRepo(t *testing.T) *metadataRepo {
+	repo := &metadataRepo{}
+	repo.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo.requests.Add(1)
+		if !strings.HasSuffix(r.URL.Path, "/foo/maven-metadata.xml") {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			repo.revalidated.Add(1)
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, metadataXml("foo", "1.2.0", "1.1.0", "1.2.0"))
+	}))
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func metadataXml(artifact string, latest string, versions ...string) string {
+	return fmt.Sprintf(`<metadata><groupId>uk.gov.hmrc</groupId><artifactId>%s</artifactId><versioning><latest>%s</latest><versions><version>%s</version></versions></versioning></metadata>`,
+		artifact, latest, strings.Join(versions, "</version><version>"))
+}
+
+func cachingServiceManager(t *testing.T, repo string) *ServiceManager {
+	sm := repoServiceManager(t, repo)
+	sm.Config.CacheDir = t.TempDir()
+	sm.Config.MetadataTtl = time.Hour
+	return sm
+}
+
+func TestMetadataIsCached(t *testing.T) {
+	repo := newMetadataRepo(t)
+	sm := cachingServiceManager(t, repo.URL)
+
+	for i := 0; i < 3; i++ {
+		metadata, err := sm.getLatestVersion("uk/gov/hmrc", "foo")
+		if err != nil || metadata.Latest != "1.2.0" || metadata.Repo != repo.URL {
+			t.Fatalf("expected foo 1.2.0 from %s, got %+v (%v)", repo.URL, metadata, err)
+		}
+	}
+	if repo.requests.Load() != 1 {
+		t.Errorf("expected the metadata to only be fetched once, got %d requests", repo.requests.Load())
+	}
+
+	// and it knows the other scala versions don't exist
+	for i := 0; i < 3; i++ {
+		if _, err := sm.getLatestVersion("uk/gov/hmrc", "foo_2.11"); err == nil {
+			t.Fatal("expected foo_2.11 not to be found")
+		}
+	}
+	if repo.requests.Load() != 2 {
+		t.Errorf("expected metadata that wasn't found to be cached too, got %d requests", repo.requests.Load())
+	}
+}
+
+func TestMetadataIsRevalidatedOnceItsExpired(t *testing.T) {
+	repo := newMetadataRepo(t)
+	sm := cachingServiceManager(t, repo.URL)
+	sm.Config.MetadataTtl = 0
+
+	for i := 0; i < 3; i++ {
+		if metadata, err := sm.getLatestVersion("uk/gov/hmrc", "foo"); err != nil || metadata.Latest != "1.2.0" {
+			t.Fatalf("expected foo 1.2.0, got %+v (%v)", metadata, err)
+		}
+	}
+	if repo.requests.Load() != 3 || repo.revalidated.Load() != 2 {
+		t.Errorf("expected every lookup after the first to be revalidated, got %d requests and %d revalidations", repo.requests.Load(), repo.revalidated.Load())
+	}
+}
+
+func TestCleanRevalidatesMetadata(t *testing.T) {
+	repo := newMetadataRepo(t)
+	sm := cachingServiceManager(t, repo.URL)
+
+	sm.getLatestVersion("uk/gov/hmrc", "foo")
+	sm.Commands.Clean = true
+	sm.getLatestVersion("uk/gov/hmrc", "foo")
+	if repo.revalidated.Load() != 1 {
+		t.Errorf("expected --clean to check the metadata is up to date")
+	}
+}
+
+func TestCachedMetadataIsUsedWhenTheRepoCantBeReached(t *testing.T) {
+	repo := newMetadataRepo(t)
+	sm := cachingServiceManager(t, repo.URL)
+	sm.Config.MetadataTtl = 0
+
+	sm.getLatestVersion("uk/gov/hmrc", "foo")
+	repo.Close()
+
+	if metadata, err := sm.getLatestVersion("uk/gov/hmrc", "foo"); err != nil || metadata.Latest != "1.2.0" {
+		t.Errorf("expected the cached metadata to be used, got %+v (%v)", metadata, err)
+	}
+}
+
+func TestOfflineOnlyUsesCachedMetadata(t *testing.T) {
+	repo := newMetadataRepo(t)
+	sm := cachingServiceManager(t, repo.URL)
+	sm.Config.MetadataTtl = 0
+
+	sm.getLatestVersion("uk/gov/hmrc", "foo")
+	sm.Commands.Offline = true
+
+	if metadata, err := sm.getLatestVersion("uk/gov/hmrc", "foo"); err != nil || metadata.Latest != "1.2.0" {
+		t.Errorf("expected the latest known version offline, got %+v (%v)", metadata, err)
+	}
+	if _, err := sm.fetchMetadata(repo.URL + "/uk/gov/hmrc/bar/maven-metadata.xml"); !errors.Is(err, errNotInRepo) {
+		t.Errorf("expected metadata that was never fetched not to be available offline, got %v", err)
+	}
+	if repo.requests.Load() != 1 {
+		t.Errorf("expected no requests to be made offline, got %d", repo.requests.Load()-1)
+	}
+}
+
+func TestWhatVersionToRunOffline(t *testing.T) {
+	foo := Service{Id: "FOO", Binary: ServiceBinary{Artifact: "foo_2.12", GroupId: "org.foo"}}
+	known := func(b ServiceBinary, s string, v string) (MavenMetadata, error) {
+		return MavenMetadata{Artifact: "foo_2.12", Group: "org.foo", Latest: "2.0.0"}, nil
+	}
+	unknown := func(b ServiceBinary, s string, v string) (MavenMetadata, error) {
+		return MavenMetadata{}, errNotInRepo
+	}
+
+	if _, _, version, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "", "", 0, false}, true, known); err != nil || version != "2.0.0" {
+		t.Errorf("expected the latest known version offline, got %s (%v)", version, err)
+	}
+	if _, _, version, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "", "", 0, false}, true, unknown); err != nil || version != "" {
+		t.Errorf("expected no version when nothing is known offline, got %s (%v)", version, err)
+	}
+}
@@ -0,0 +1,146 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_PORT_RANGE = "20000-20999"
+
+// The ports given to the services in the current batch. With --auto-port services started together need to
+// know about each other, both so they don't end up on the same port and so dependents can be told where to
+// find their dependencies.
+type portAllocator struct {
+	lock      sync.Mutex
+	allocated map[string]int // service -> port
+}
+
+func newPortAllocator() *portAllocator {
+	return &portAllocator{allocated: map[string]int{}}
+}
+
+// parses a range of ports, e.g. 20000-20999
+func parsePortRange(portRange string) (int, int, error) {
+	from, to, found := strings.Cut(portRange, "-")
+	min, minErr := strconv.Atoi(strings.TrimSpace(from))
+	max, maxErr := strconv.Atoi(strings.TrimSpace(to))
+	if !found || minErr != nil || maxErr != nil || min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %s, expected MIN-MAX e.g. %s", portRange, DEFAULT_PORT_RANGE)
+	}
+	return min, max, nil
+}
+
+// checks nothing else is listening on a port, lsof doesn't always see everything (e.g. other users' processes)
+func portIsFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// Works out which port to start a service on. Normally that's its default port (or the one asked for), but with
+// --auto-port a free port from --port-range is used instead if the default one is already taken.
+func (sm *ServiceManager) resolvePort(service Service, requestedPort int) (int, error) {
+	port := findPort(service, requestedPort)
+	if !sm.Commands.AutoPort || requestedPort > 0 {
+		return port, nil
+	}
+
+	if sm.ports == nil {
+		sm.ports = newPortAllocator()
+	}
+	sm.ports.lock.Lock()
+	defer sm.ports.lock.Unlock()
+
+	// if its already running leave it be, it may have been given a different port last time
+	installDir, _ := sm.findInstallDirOfService(service.Id)
+	if state, err := sm.Ledger.LoadStateFile(installDir); err == nil {
+		if _, running := sm.Platform.PidLookup()[state.Pid]; running {
+			sm.ports.allocated[service.Id] = state.Port
+			return state.Port, nil
+		}
+	}
+
+	taken := map[int]bool{}
+	for p := range sm.Platform.PortPidLookup() {
+		taken[p] = true
+	}
+	for _, p := range sm.ports.allocated {
+		taken[p] = true
+	}
+
+	if !taken[port] && portIsFree(port) {
+		sm.ports.allocated[service.Id] = port
+		return port, nil
+	}
+
+	// don't hand out a port that another service would normally use
+	for _, s := range sm.Services {
+		taken[s.DefaultPort] = true
+	}
+
+	portRange := sm.Commands.PortRange
+	if portRange == "" {
+		portRange = DEFAULT_PORT_RANGE
+	}
+	min, max, err := parsePortRange(portRange)
+	if err != nil {
+		return 0, err
+	}
+	for p := min; p <= max; p++ {
+		if !taken[p] && portIsFree(p) {
+			sm.ports.allocated[service.Id] = p
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("port %d is in use and there are no free ports in %s", port, portRange)
+}
+
+// With --auto-port a service's dependencies might not be on their usual ports, so tell it where they've been
+// started, the same way it would be configured in an application.conf.
+func (sm *ServiceManager) dependencyPortArgs(service Service) []string {
+	args := []string{}
+	if sm.ports == nil {
+		return args
+	}
+
+	sm.ports.lock.Lock()
+	defer sm.ports.lock.Unlock()
+
+	for _, dep := range service.DependsOn {
+		port, ok := sm.ports.allocated[dep]
+		depService, known := sm.Services[dep]
+		if !ok || !known || port == depService.DefaultPort {
+			continue
+		}
+		args = append(args, fmt.Sprintf("-Dmicroservice.services.%s.port=%d", servicesConfigName(depService), port))
+	}
+	return args
+}
+
+// the name a service usually goes by under microservice.services in other services' config, i.e. its artifact
+// without the scala version (cart-backend_2.13 is cart-backend)
+func servicesConfigName(service Service) string {
+	if service.Binary.Artifact != "" {
+		return scalaSuffix.ReplaceAllLiteralString(service.Binary.Artifact, "")
+	}
+	return strings.ReplaceAll(strings.ToLower(service.Id), "_", "-")
+}
+
+// the ports services were actually started on, from their .state files
+func (sm *ServiceManager) startedPorts() map[string]int {
+	ports := map[string]int{}
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return ports
+	}
+	for _, state := range states {
+		ports[state.Service] = state.Port
+	}
+	return ports
+}
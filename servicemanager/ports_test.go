@@ -0,0 +1,157 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func autoPortServiceManager(t *testing.T, portsInUse map[int]int) *ServiceManager {
+	return &ServiceManager{
+		Services: map[string]Service{
+			"FOO": {Id: "FOO", DefaultPort: 45001, Binary: ServiceBinary{Artifact: "foo-backend_2.13"}},
+			"BAR": {Id: "BAR", DefaultPort: 45001, DependsOn: []string{"FOO"}},
+			"BAZ": {Id: "BAZ", DefaultPort: 45101},
+		},
+		Commands: cli.UserOption{AutoPort: true, Port: -1, PortRange: "45100-45110"},
+		Config:   ServiceManagerConfig{TmpDir: t.TempDir()},
+		Platform: platform.Platform{
+			PidLookup:     func() map[int]int { return map[int]int{999: 1} },
+			PortPidLookup: func() map[int]int { return portsInUse },
+		},
+		Ledger: ledger.Ledger{
+			LoadStateFile: func(installDir string) (ledger.StateFile, error) {
+				return ledger.StateFile{}, fmt.Errorf("no state file in %s", installDir)
+			},
+		},
+		ports: newPortAllocator(),
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if min, max, err := parsePortRange("20000-20999"); err != nil || min != 20000 || max != 20999 {
+		t.Errorf("expected 20000-20999, got %d-%d (%v)", min, max, err)
+	}
+	for _, invalid := range []string{"", "20000", "20999-20000", "0-10", "20000-70000", "a-b"} {
+		if _, _, err := parsePortRange(invalid); err == nil {
+			t.Errorf("expected %q to be an invalid port range", invalid)
+		}
+	}
+}
+
+func TestResolvePortUsesDefaultWhenFree(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{})
+
+	port, err := sm.resolvePort(sm.Services["FOO"], 0)
+	if err != nil || port != 45001 {
+		t.Errorf("expected FOO to get its default port, got %d (%v)", port, err)
+	}
+}
+
+func TestResolvePortAllocatesWhenDefaultIsTaken(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{45001: 123, 45100: 456})
+
+	port, err := sm.resolvePort(sm.Services["FOO"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 45100 is in use and 45101 is BAZ's default port
+	if port != 45102 {
+		t.Errorf("expected FOO to be given 45102, got %d", port)
+	}
+}
+
+func TestResolvePortDoesntGiveOutTheSamePortTwice(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{})
+
+	foo, _ := sm.resolvePort(sm.Services["FOO"], 0)
+	bar, err := sm.resolvePort(sm.Services["BAR"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foo != 45001 || bar == foo || bar < 45100 || bar > 45110 {
+		t.Errorf("expected FOO and BAR to be given different ports, got %d and %d", foo, bar)
+	}
+}
+
+func TestResolvePortKeepsRunningServiceWhereItIs(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{45001: 123})
+	installDir, _ := sm.findInstallDirOfService("FOO")
+	sm.Ledger.LoadStateFile = func(string) (ledger.StateFile, error) {
+		return ledger.StateFile{Service: "FOO", Path: installDir, Pid: 999, Port: 45105}, nil
+	}
+
+	port, err := sm.resolvePort(sm.Services["FOO"], 0)
+	if err != nil || port != 45105 {
+		t.Errorf("expected FOO to stay on 45105, got %d (%v)", port, err)
+	}
+}
+
+func TestResolvePortFailsWhenRangeIsFull(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{45001: 1, 45100: 1, 45102: 1, 45103: 1, 45104: 1, 45105: 1, 45106: 1, 45107: 1, 45108: 1, 45109: 1, 45110: 1})
+
+	if _, err := sm.resolvePort(sm.Services["FOO"], 0); err == nil {
+		t.Errorf("expected an error when there are no free ports")
+	}
+}
+
+func TestResolvePortWithoutAutoPort(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{45001: 123})
+	sm.Commands.AutoPort = false
+
+	port, err := sm.resolvePort(sm.Services["FOO"], 0)
+	if err != nil || port != 45001 {
+		t.Errorf("expected FOO to be given its default port without --auto-port, got %d (%v)", port, err)
+	}
+}
+
+func TestResolvePortUsesRequestedPort(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{45050: 123})
+
+	port, err := sm.resolvePort(sm.Services["FOO"], 45050)
+	if err != nil || port != 45050 {
+		t.Errorf("expected FOO to be given the port that was asked for, got %d (%v)", port, err)
+	}
+}
+
+func TestPortIsFree(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if portIsFree(listener.Addr().(*net.TCPAddr).Port) {
+		t.Errorf("expected a port that's being listened on not to be free")
+	}
+}
+
+func TestDependencyPortArgs(t *testing.T) {
+	sm := autoPortServiceManager(t, map[int]int{})
+	sm.ports.allocated["FOO"] = 45104
+
+	expected := []string{"-Dmicroservice.services.foo-backend.port=45104"}
+	if args := sm.dependencyPortArgs(sm.Services["BAR"]); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+
+	// nothing is needed if its on its usual port
+	sm.ports.allocated["FOO"] = 45001
+	if args := sm.dependencyPortArgs(sm.Services["BAR"]); len(args) != 0 {
+		t.Errorf("expected no args when FOO is on its default port, got %v", args)
+	}
+}
+
+func TestServicesConfigName(t *testing.T) {
+	if name := servicesConfigName(Service{Id: "CART_BACKEND", Binary: ServiceBinary{Artifact: "cart-backend_2.13"}}); name != "cart-backend" {
+		t.Errorf("expected cart-backend, got %s", name)
+	}
+	if name := servicesConfigName(Service{Id: "CART_BACKEND"}); name != "cart-backend" {
+		t.Errorf("expected cart-backend, got %s", name)
+	}
+}
@@ -0,0 +1,42 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// how the processes started by this instance of sm2 exited, keyed by pid
+var exitedProcesses sync.Map
+
+// waits for the process in the background, recording how it exited. Waiting also reaps
+// the process so it doesn't linger as a zombie while sm2 is still running.
+func waitForExit(cmd *exec.Cmd) {
+	go func() {
+		cmd.Wait()
+		exitedProcesses.Store(cmd.Process.Pid, cmd.ProcessState)
+	}()
+}
+
+// returns how a process exited, if it was started (and has exited) while this instance of sm2 was running
+func lookupExit(pid int) (*os.ProcessState, bool) {
+	if state, ok := exitedProcesses.Load(pid); ok {
+		return state.(*os.ProcessState), true
+	}
+	return nil, false
+}
+
+// describes how a process exited, e.g. `exit code 1` or `killed by signal 9 (killed)`
+func describeExit(state *os.ProcessState) string {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return fmt.Sprintf("killed by signal %d (%s)", int(status.Signal()), status.Signal())
+	}
+	return fmt.Sprintf("exit code %d", state.ExitCode())
+}
+
+func killedBy(state *os.ProcessState, sig syscall.Signal) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == sig
+}
@@ -51,6 +51,7 @@ func (pr *ProgressRenderer) init(services []ServiceAndVersion) {
 	pr.state = map[string]Progress{}
 	pr.errors = map[string]error{}
 	pr.serviceLen = 14
+	pr.watchlist = []string{}
 
 	for _, s := range services {
 		pr.watchlist = append(pr.watchlist, s.service)
@@ -0,0 +1,64 @@
+package servicemanager
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// returned when a repo doesn't have what was asked for, so the next one can be tried
+var errNotInRepo = errors.New("not found")
+
+// serves file:// urls, so a local maven repo (e.g. ~/.m2/repository) can be used like any other
+var fileTransport = http.NewFileTransport(http.Dir("/"))
+
+// the repos to look for services in, in the order they're tried
+func (sm *ServiceManager) repositories() []string {
+	return append([]string{sm.Config.ArtifactoryRepoUrl}, sm.Config.FallbackRepoUrls...)
+}
+
+func isFileRepo(repoUrl string) bool {
+	return strings.HasPrefix(repoUrl, "file://")
+}
+
+// sends a request to a repo, reading it straight from disk if its a local one
+func (sm *ServiceManager) doArtifactoryRequest(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		return fileTransport.RoundTrip(req)
+	}
+	return artifactoryClient(sm.Client).Do(req)
+}
+
+// A copy of the client that drops the credentials if artifactory redirects somewhere else, e.g. a download to S3 or
+// a CDN, or from https to http, where they'd be sent in the clear. Go only does this for the Authorization header on
+// another host, and copies any others (like X-JFrog-Art-Api) as they are.
+func artifactoryClient(client *http.Client) *http.Client {
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		downgraded := via[0].URL.Scheme == "https" && req.URL.Scheme != "https"
+		if req.URL.Host != via[0].URL.Host || downgraded {
+			req.Header.Del("Authorization")
+			req.Header.Del("X-JFrog-Art-Api")
+		}
+		return nil
+	}
+	return &c
+}
+
+// credentials are only sent to the host they're for, not to fallback repos somewhere else
+func sendsCredentials(config ServiceManagerConfig, requestUrl string) bool {
+	req, err := url.Parse(requestUrl)
+	if err != nil || req.Scheme == "file" {
+		return false
+	}
+	// if we don't know where artifactory is we can't tell if this is it
+	repo, err := url.Parse(config.ArtifactoryRepoUrl)
+	if err != nil || repo.Host == "" {
+		return false
+	}
+	return repo.Host == req.Host
+}
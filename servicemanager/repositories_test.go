@@ -0,0 +1,257 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+)
+
+const snapshotMetadata string = `
+<metadata modelVersion="1.1.0">
+<groupId>foo.bar</groupId>
+<artifactId>playtest</artifactId>
+<versioning>
+<latest>1.0.1-SNAPSHOT</latest>
+<release>1.0.1-SNAPSHOT</release>
+<versions>
+<version>1.0.0</version>
+<version>1.0.1-SNAPSHOT</version>
+</versions>
+</versioning>
+</metadata>
+`
+
+// a stub repo with metadata for playtest, and optionally the 1.0.0 tarball
+func stubRepo(t *testing.T, metadata string, hasTarball bool) *httptest.Server {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "maven-metadata.xml") && metadata != "":
+			fmt.Fprint(w, metadata)
+		case strings.HasSuffix(r.URL.Path, "playtest-1.0.0.tgz") && hasTarball:
+			http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	t.Cleanup(svr.Close)
+	return svr
+}
+
+func repoServiceManager(t *testing.T, repos ...string) *ServiceManager {
+	return &ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{
+			ArtifactoryRepoUrl: repos[0],
+			FallbackRepoUrls:   repos[1:],
+			TimeoutShort:       4 * time.Second,
+		},
+		Ledger:   ledger.NewLedger(),
+		progress: ProgressRenderer{noProgress: true},
+	}
+}
+
+func TestGetLatestVersionMergesVersionsFromAllRepos(t *testing.T) {
+	release := stubRepo(t, mavenMetadata, false)
+	snapshots := stubRepo(t, snapshotMetadata, false)
+	sm := repoServiceManager(t, release.URL, snapshots.URL)
+
+	metadata, err := sm.getLatestVersion("foo/bar", "playtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Repo != release.URL {
+		t.Errorf("expected the metadata to come from the release repo, got %s", metadata.Repo)
+	}
+	if metadata.Latest == "1.0.1-SNAPSHOT" {
+		t.Errorf("expected the latest version to come from the release repo")
+	}
+	if !metadata.ContainsVersion("1.0.1-SNAPSHOT") {
+		t.Errorf("expected versions from the snapshot repo to be included, got %v", metadata.Versions)
+	}
+}
+
+func TestGetLatestVersionFallsBackWhenNotInFirstRepo(t *testing.T) {
+	release := stubRepo(t, "", false)
+	snapshots := stubRepo(t, snapshotMetadata, false)
+	sm := repoServiceManager(t, release.URL, snapshots.URL)
+
+	metadata, err := sm.getLatestVersion("foo/bar", "playtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Repo != snapshots.URL || metadata.Latest != "1.0.1-SNAPSHOT" {
+		t.Errorf("expected the metadata from the snapshot repo, got %s from %s", metadata.Latest, metadata.Repo)
+	}
+
+	sm = repoServiceManager(t, release.URL)
+	if _, err := sm.getLatestVersion("foo/bar", "playtest"); err == nil || !strings.Contains(err.Error(), release.URL) {
+		t.Errorf("expected an error listing the repos that were tried, got %v", err)
+	}
+}
+
+func TestInstallServiceTriesEachRepo(t *testing.T) {
+	release := stubRepo(t, mavenMetadata, false)
+	team := stubRepo(t, mavenMetadata, true)
+	sm := repoServiceManager(t, release.URL, team.URL)
+	installDir := t.TempDir()
+
+	installFile, err := sm.installService(installDir, "PLAYTEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installFile.Repo != team.URL {
+		t.Errorf("expected the install to come from %s, got %s", team.URL, installFile.Repo)
+	}
+
+	saved, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil || saved.Repo != team.URL {
+		t.Errorf("expected the repo to be recorded in the install file, got %s (%v)", saved.Repo, err)
+	}
+}
+
+func TestInstallServiceFromLocalRepo(t *testing.T) {
+	localRepo := t.TempDir()
+	artifactDir := path.Join(localRepo, "foo", "bar", "playtest", "1.0.0")
+	os.MkdirAll(artifactDir, 0755)
+	tgz, _ := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	os.WriteFile(path.Join(artifactDir, "playtest-1.0.0.tgz"), tgz, 0644)
+	os.WriteFile(path.Join(localRepo, "foo", "bar", "playtest", "maven-metadata-local.xml"), []byte(mavenMetadata), 0644)
+
+	release := stubRepo(t, "", false)
+	sm := repoServiceManager(t, release.URL, "file://"+localRepo)
+
+	metadata, err := sm.getLatestVersion("foo/bar", "playtest")
+	if err != nil || metadata.Repo != "file://"+localRepo {
+		t.Errorf("expected the metadata from the local repo, got %s (%v)", metadata.Repo, err)
+	}
+
+	installFile, err := sm.installService(t.TempDir(), "PLAYTEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installFile.Repo != "file://"+localRepo || !Exists(path.Join(installFile.Path, "lib", "foo.jar")) {
+		t.Errorf("expected playtest to be installed from the local repo, got %+v", installFile)
+	}
+}
+
+func TestInstallServiceNotInAnyRepo(t *testing.T) {
+	release := stubRepo(t, mavenMetadata, false)
+	sm := repoServiceManager(t, release.URL, "file://"+t.TempDir())
+
+	_, err := sm.installService(t.TempDir(), "PLAYTEST", "foo.bar", "playtest", "1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "was not found in "+release.URL) {
+		t.Errorf("expected an error listing the repos that were tried, got %v", err)
+	}
+}
+
+func TestLoadRepoConfigFallbackRepos(t *testing.T) {
+	configDir := t.TempDir()
+	os.WriteFile(path.Join(configDir, "config.json"), []byte(`{
+  "artifactory": {
+    "protocol": "https",
+    "host": "artefacts.example.com",
+    "repoMappings": {"RELEASE": "artifactory/releases", "SNAPSHOT": "artifactory/snapshots"},
+    "fallbackRepos": ["SNAPSHOT", "file:///home/me/.m2/repository/", "UNKNOWN"],
+    "ping": "artifactory/api/system/ping"
+  }
+}`), 0644)
+
+	urls, err := loadRepoConfig(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://artefacts.example.com/artifactory/snapshots", "file:///home/me/.m2/repository"}
+	if strings.Join(urls.FallbackRepoUrls, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected fallback repos %v, got %v", expected, urls.FallbackRepoUrls)
+	}
+	if urls.RepoUrl != "https://artefacts.example.com/artifactory/releases" {
+		t.Errorf("expected the release repo to be first, got %s", urls.RepoUrl)
+	}
+}
+
+func TestCredentialsAreOnlySentToTheArtifactoryHost(t *testing.T) {
+	config := ServiceManagerConfig{
+		ArtifactoryRepoUrl: "https://artefacts.example.com/artifactory/releases",
+		ArtifactoryAuth:    ArtifactoryAuth{Token: "abc123"},
+	}
+	for url, expected := range map[string]bool{
+		"https://artefacts.example.com/artifactory/snapshots/foo": true,
+		"https://team.example.com/repo/foo":                       false,
+		"file:///home/me/.m2/repository/foo":                      false,
+	} {
+		req, _ := newArtifactoryRequest(t.Context(), config, url)
+		if sent := req.Header.Get("Authorization") != ""; sent != expected {
+			t.Errorf("expected credentials sent to %s to be %v", url, expected)
+		}
+	}
+}
+
+func TestCredentialsAreNotSentWithoutAnArtifactoryHost(t *testing.T) {
+	config := ServiceManagerConfig{ArtifactoryAuth: ArtifactoryAuth{Token: "abc123"}}
+	req, _ := newArtifactoryRequest(t.Context(), config, "https://team.example.com/repo/foo")
+	if req.Header.Get("Authorization") != "" {
+		t.Errorf("expected no credentials to be sent when the artifactory host isn't known")
+	}
+}
+
+func TestCredentialsAreNotSentWhenRedirectedToAnotherHost(t *testing.T) {
+	var leaked []string
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range []string{"Authorization", "X-JFrog-Art-Api"} {
+			if r.Header.Get(header) != "" {
+				leaked = append(leaked, header)
+			}
+		}
+		http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+	}))
+	defer cdn.Close()
+	artifactory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cdn.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer artifactory.Close()
+
+	for _, auth := range []ArtifactoryAuth{{Token: "abc123"}, {ApiKey: "abc123"}, {Username: "me", Password: "abc123"}} {
+		sm := repoServiceManager(t, artifactory.URL)
+		sm.Config.ArtifactoryAuth = auth
+
+		progress := ProgressWriter{renderer: &ProgressRenderer{noProgress: true}}
+		if _, _, err := sm.downloadAndDecompress(artifactory.URL+"/playtest-1.0.0.tgz", t.TempDir(), &progress); err != nil {
+			t.Fatal(err)
+		}
+		if len(leaked) > 0 {
+			t.Errorf("expected the %s not to be sent to %s, got %v", auth.describe(), cdn.URL, leaked)
+		}
+		leaked = nil
+	}
+}
+
+func TestCredentialsAreNotSentWhenRedirectedToHttp(t *testing.T) {
+	checkRedirect := artifactoryClient(&http.Client{}).CheckRedirect
+	original, _ := http.NewRequest("GET", "https://artefacts.example.com/foo.tgz", nil)
+
+	tests := map[string]bool{
+		"https://artefacts.example.com/bar.tgz": true,
+		"http://artefacts.example.com/bar.tgz":  false,
+		"https://cdn.example.com/bar.tgz":       false,
+	}
+	for location, sent := range tests {
+		req, _ := http.NewRequest("GET", location, nil)
+		req.Header.Set("Authorization", "Bearer abc123")
+		req.Header.Set("X-JFrog-Art-Api", "abc123")
+		if err := checkRedirect(req, []*http.Request{original}); err != nil {
+			t.Fatal(err)
+		}
+		for _, header := range []string{"Authorization", "X-JFrog-Art-Api"} {
+			if (req.Header.Get(header) != "") != sent {
+				t.Errorf("redirect to %s: expected %s to be sent %v", location, header, sent)
+			}
+		}
+	}
+}
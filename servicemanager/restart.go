@@ -38,12 +38,23 @@ func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
 		return err
 	}
 
-	// start a new instance
+	// start a new instance, on the port it was running on unless its been given a new one
+	port := state.Port
+	if sv.port > 0 {
+		port = sv.port
+	}
 	fmt.Printf("Restarting %s...\n", sv.service)
-	newstate, err := run(service, install, state.Args, state.Port)
+	env, err := sm.relaunchEnv(service, state)
+	if err != nil {
+		return err
+	}
+	newstate, err := run(service, install, withoutPortArg(state.Args), port, env)
 	if err != nil {
 		return err
 	}
+	newstate.HealthcheckUrl = findHealthcheckUrl(service, port)
+	newstate.RestartPolicy = state.RestartPolicy
+	newstate.MaxRestarts = state.MaxRestarts
 
 	// save the new pid
 	return sm.Ledger.SaveStateFile(installDir, newstate)
@@ -10,7 +10,7 @@ func (sm *ServiceManager) RestartOutdated() {
 
 	// check for a newer version for each service running
 	for _, status := range sm.findStatuses() {
-		serviceAndVersion := ServiceAndVersion{status.service, "", ""}
+		serviceAndVersion := ServiceAndVersion{status.service, "", "", 0, false}
 		_, _, LatestVersion, _ := whatVersionToRun(
 			sm.Services[status.service],
 			serviceAndVersion,
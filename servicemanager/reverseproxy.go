@@ -30,9 +30,9 @@ func (sm *ServiceManager) StartProxy() {
 				definedServices[v.service] = s
 			}
 		}
-		routes = buildRoutingTable(definedServices)
+		routes = buildRoutingTable(definedServices, sm.startedPorts())
 	} else {
-		routes = buildRoutingTable(sm.Services)
+		routes = buildRoutingTable(sm.Services, sm.startedPorts())
 	}
 
 	log.Printf("ReverseProxy: Loaded %d frontend routes\n", len(routes))
@@ -76,12 +76,17 @@ func (sm *ServiceManager) StartProxy() {
 	log.Fatal(server.ListenAndServe())
 }
 
-func buildRoutingTable(services map[string]Service) map[string]string {
+// routes each service's proxy paths to the port its running on, or its default port if it hasn't been started
+func buildRoutingTable(services map[string]Service, startedPorts map[string]int) map[string]string {
 	routes := map[string]string{}
 	for _, v := range services {
+		port := v.DefaultPort
+		if startedPort, ok := startedPorts[v.Id]; ok {
+			port = startedPort
+		}
 		for _, path := range v.ProxyPaths {
-			routes[path] = fmt.Sprintf("localhost:%d", v.DefaultPort)
-			log.Printf("Setup: routing %s to %s on port %s\n", path, v.Id, fmt.Sprint(v.DefaultPort))
+			routes[path] = fmt.Sprintf("localhost:%d", port)
+			log.Printf("Setup: routing %s to %s on port %s\n", path, v.Id, fmt.Sprint(port))
 		}
 	}
 	return routes
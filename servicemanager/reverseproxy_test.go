@@ -6,7 +6,7 @@ import (
 
 func Test_buildRoutingTable(t *testing.T) {
 	service := Service{Id: "Foo", DefaultPort: 8080, ProxyPaths: []string{"/path1", "/path2"}}
-	result := buildRoutingTable(map[string]Service{"Foo": service})
+	result := buildRoutingTable(map[string]Service{"Foo": service}, map[string]int{})
 	if v, ok := result["/path1"]; !ok || v != "localhost:8080" {
 		t.Errorf("Routes /path1 did not have expected value. Expected value was %s actual value was %s", "localhost:8080", v)
 	}
@@ -14,3 +14,11 @@ func Test_buildRoutingTable(t *testing.T) {
 		t.Errorf("Routes /path2 did not have expected value. Expected value was %s actual value was %s", "localhost:8080", v)
 	}
 }
+
+func Test_buildRoutingTableUsesStartedPort(t *testing.T) {
+	service := Service{Id: "Foo", DefaultPort: 8080, ProxyPaths: []string{"/path1"}}
+	result := buildRoutingTable(map[string]Service{"Foo": service}, map[string]int{"Foo": 20001})
+	if v := result["/path1"]; v != "localhost:20001" {
+		t.Errorf("Expected /path1 to be routed to the port Foo was started on, got %s", v)
+	}
+}
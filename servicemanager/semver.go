@@ -0,0 +1,235 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A semantic version, e.g. 1.2.3 or 2.0.0-RC1. Partial versions like 1.2 are allowed so they can be used in ranges.
+type semver struct {
+	major, minor, patch int
+	pre                 []string // prerelease identifiers, e.g. [RC 1] for 1.0.0-RC.1
+	parts               int      // how many of major, minor and patch were given
+}
+
+func parseSemver(version string) (semver, error) {
+	v := semver{}
+	s := strings.TrimPrefix(version, "v")
+
+	// build metadata doesn't affect precedence
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("invalid version %s", version)
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+
+	core := strings.Split(s, ".")
+	if len(core) > 3 {
+		return v, fmt.Errorf("invalid version %s", version)
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range core {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %s", version)
+		}
+		*nums[i] = n
+	}
+	v.parts = len(core)
+	return v, nil
+}
+
+// compares two versions following the semver precedence rules, returning -1, 0 or 1
+func (v semver) compare(other semver) int {
+	for _, pair := range [][2]int{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+
+	// a prerelease comes before its release
+	switch {
+	case len(v.pre) == 0 && len(other.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(other.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(other.pre); i++ {
+		if c := comparePrerelease(v.pre[i], other.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.pre), len(other.pre))
+}
+
+// numeric identifiers are compared as numbers and come before alphanumeric ones, which are compared as strings
+func comparePrerelease(a string, b string) int {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNum, bNum)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (v semver) sameRelease(other semver) bool {
+	return v.major == other.major && v.minor == other.minor && v.patch == other.patch
+}
+
+// Whether a version is a range like ^2.3, ~1.4.0 or >=1.0.0 <2.0.0 rather than an exact version. A partial version
+// like 1.4 is a range too (see versionConstraint).
+func isVersionConstraint(version string) bool {
+	if strings.ContainsAny(version, "^~<>= ") {
+		return true
+	}
+	v, err := parseSemver(version)
+	return err == nil && v.parts < 3 && len(v.pre) == 0
+}
+
+type comparator struct {
+	op      string // one of >=, >, <=, < or =
+	version semver
+	limit   bool // the upper limit of a ^ or ~ range
+}
+
+func (c comparator) matches(v semver) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp == 0
+}
+
+// A version range, which matches a version if all of its comparators do. Supports:
+//
+//	^2.3         >=2.3.0 <3.0.0 (compatible with 2.3, ^0.2 is >=0.2.0 <0.3.0)
+//	~1.4.0       >=1.4.0 <1.5.0 (patches of 1.4)
+//	1.4          the same as ~1.4
+//	>=1.2.0 <2   any of >=, >, <=, < and =, separated by spaces
+type versionConstraint struct {
+	raw         string
+	comparators []comparator
+}
+
+func parseVersionConstraint(constraint string) (versionConstraint, error) {
+	c := versionConstraint{raw: constraint}
+	terms := strings.Fields(constraint)
+	if len(terms) == 0 {
+		return c, fmt.Errorf("invalid version range %q", constraint)
+	}
+
+	for _, term := range terms {
+		op := ""
+		for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(term, prefix) {
+				op = prefix
+				break
+			}
+		}
+		v, err := parseSemver(strings.TrimPrefix(term, op))
+		if err != nil {
+			return c, fmt.Errorf("invalid version range %q: %s", constraint, err)
+		}
+
+		switch {
+		case op == "^":
+			c.comparators = append(c.comparators, comparator{">=", v, false}, comparator{"<", caretLimit(v), true})
+		case op == "~" || (op == "" && v.parts < 3):
+			c.comparators = append(c.comparators, comparator{">=", v, false}, comparator{"<", tildeLimit(v), true})
+		case op == "":
+			c.comparators = append(c.comparators, comparator{"=", v, false})
+		default:
+			c.comparators = append(c.comparators, comparator{op, v, false})
+		}
+	}
+	return c, nil
+}
+
+// the first version that isn't compatible with v, i.e. the next change to the leftmost non-zero part
+func caretLimit(v semver) semver {
+	switch {
+	case v.major > 0 || v.parts == 1:
+		return semver{major: v.major + 1, pre: []string{"0"}}
+	case v.minor > 0 || v.parts == 2:
+		return semver{minor: v.minor + 1, pre: []string{"0"}}
+	}
+	return semver{patch: v.patch + 1, pre: []string{"0"}}
+}
+
+// the next minor version, or the next major one if only the major was given
+func tildeLimit(v semver) semver {
+	if v.parts == 1 {
+		return semver{major: v.major + 1, pre: []string{"0"}}
+	}
+	return semver{major: v.major, minor: v.minor + 1, pre: []string{"0"}}
+}
+
+// Prereleases only match if the range mentions a prerelease of the same version, so ^1.2 never picks up
+// 2.0.0-RC1 or 1.3.0-SNAPSHOT.
+func (c versionConstraint) matches(v semver) bool {
+	if len(v.pre) > 0 {
+		allowed := false
+		for _, comp := range c.comparators {
+			// the limits of ^ and ~ are prereleases so that 2.0.0-RC1 is outside of ^1.2, but they don't count
+			if len(comp.version.pre) > 0 && comp.version.sameRelease(v) && !comp.limit {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, comp := range c.comparators {
+		if !comp.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// the newest of the versions that's in the range
+func (c versionConstraint) resolve(versions []string) (string, error) {
+	best := ""
+	var bestVersion semver
+	for _, version := range versions {
+		v, err := parseSemver(version)
+		if err != nil || !c.matches(v) {
+			continue
+		}
+		if best == "" || v.compare(bestVersion) > 0 {
+			best, bestVersion = version, v
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no version matches %s", c.raw)
+	}
+	return best, nil
+}
@@ -0,0 +1,178 @@
+package servicemanager
+
+import (
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	valid := map[string]semver{
+		"1.2.3":          {major: 1, minor: 2, patch: 3, parts: 3},
+		"v1.2.3":         {major: 1, minor: 2, patch: 3, parts: 3},
+		"1.2":            {major: 1, minor: 2, parts: 2},
+		"2.0.0-RC.1":     {major: 2, pre: []string{"RC", "1"}, parts: 3},
+		"1.0.0+build.5":  {major: 1, parts: 3},
+		"0.499.0":        {minor: 499, parts: 3},
+		"3.0.0-SNAPSHOT": {major: 3, pre: []string{"SNAPSHOT"}, parts: 3},
+	}
+	for version, expected := range valid {
+		v, err := parseSemver(version)
+		if err != nil || v.compare(expected) != 0 || v.parts != expected.parts {
+			t.Errorf("expected %s to parse as %+v, got %+v (%v)", version, expected, v, err)
+		}
+	}
+
+	for _, version := range []string{"", "local", "1.2.3.4", "1.x", "1.0.0-", "-1.0.0"} {
+		if _, err := parseSemver(version); err == nil {
+			t.Errorf("expected %q not to be a valid version", version)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// in order, oldest first
+	ordered := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.2.0",
+		"1.10.0",
+		"10.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+	if a, b := mustParseSemver(t, "1.2.3+one"), mustParseSemver(t, "1.2.3+two"); a.compare(b) != 0 {
+		t.Errorf("expected build metadata to be ignored")
+	}
+}
+
+func mustParseSemver(t *testing.T, version string) semver {
+	v, err := parseSemver(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVersionConstraintMatches(t *testing.T) {
+	cases := map[string]map[string]bool{
+		"^2.3": {
+			"2.3.0": true, "2.9.1": true, "2.2.9": false, "3.0.0": false, "3.0.0-RC1": false, "2.4.0-RC1": false,
+		},
+		"^0.2.3": {
+			"0.2.3": true, "0.2.9": true, "0.3.0": false, "0.2.2": false,
+		},
+		"^0.0.3": {
+			"0.0.3": true, "0.0.4": false,
+		},
+		"~1.4.0": {
+			"1.4.0": true, "1.4.7": true, "1.5.0": false, "1.3.9": false,
+		},
+		"~1": {
+			"1.0.0": true, "1.9.9": true, "2.0.0": false,
+		},
+		"1.4": {
+			"1.4.2": true, "1.5.0": false,
+		},
+		"=1.2.3": {
+			"1.2.3": true, "1.2.4": false,
+		},
+		">=1.2.0 <2": {
+			"1.2.0": true, "1.99.0": true, "2.0.0": false, "1.1.9": false, "2.0.0-RC1": false,
+		},
+		">1.0.0 <=1.1.0": {
+			"1.0.0": false, "1.0.1": true, "1.1.0": true,
+		},
+		"^1.2.0-RC.1": {
+			"1.2.0-RC.1": true, "1.2.0-RC.2": true, "1.2.0": true, "1.3.0-RC.1": false,
+		},
+	}
+	for raw, versions := range cases {
+		constraint, err := parseVersionConstraint(raw)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", raw, err)
+			continue
+		}
+		for version, expected := range versions {
+			if constraint.matches(mustParseSemver(t, version)) != expected {
+				t.Errorf("expected %s matching %s to be %v", version, raw, expected)
+			}
+		}
+	}
+}
+
+func TestIsVersionConstraint(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"1.2.3": false, "2.0.0-SNAPSHOT": false, "1.4": true, "2": true, "^2.3": true, ">=1.0.0 <2": true, "local": false,
+	} {
+		if isVersionConstraint(version) != expected {
+			t.Errorf("expected isVersionConstraint(%q) to be %v", version, expected)
+		}
+	}
+
+	// FOO:1.4 on the command line or in a profile is a range
+	if sv := parseServiceAndVersion("FOO:1.4"); !isVersionConstraint(sv.version) {
+		t.Errorf("expected FOO:1.4 to be a range, got %q", sv.version)
+	}
+}
+
+func TestVersionConstraintResolve(t *testing.T) {
+	versions := []string{"1.9.0", "2.3.0", "2.10.1", "2.11.0-RC1", "3.0.0", "not-a-version"}
+
+	constraint, _ := parseVersionConstraint("^2.3")
+	if version, err := constraint.resolve(versions); err != nil || version != "2.10.1" {
+		t.Errorf("expected ^2.3 to resolve to 2.10.1, got %s (%v)", version, err)
+	}
+
+	constraint, _ = parseVersionConstraint("^4")
+	if _, err := constraint.resolve(versions); err == nil {
+		t.Errorf("expected nothing to match ^4")
+	}
+
+	for _, raw := range []string{"^", "~x", ">=1.0.0 <banana"} {
+		if _, err := parseVersionConstraint(raw); err == nil {
+			t.Errorf("expected %q not to be a valid range", raw)
+		}
+	}
+}
+
+func TestWhatVersionToRunResolvesRanges(t *testing.T) {
+	foo := Service{Id: "FOO", Binary: ServiceBinary{Artifact: "foo_%%", GroupId: "org.foo"}}
+	latestFunc := func(b ServiceBinary, s string, v string) (MavenMetadata, error) {
+		return MavenMetadata{Artifact: "foo_2.13", Group: "org.foo", Latest: "3.0.0", Versions: []string{"2.3.0", "2.4.1", "3.0.0"}}, nil
+	}
+
+	group, artifact, version, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "^2.3", "", 0, false}, false, latestFunc)
+	if err != nil || group != "org.foo" || artifact != "foo_2.13" || version != "2.4.1" {
+		t.Errorf("expected org.foo:foo_2.13:2.4.1, got %s %s %s (%v)", group, artifact, version, err)
+	}
+
+	// a partial version is the newest patch of it
+	if _, _, version, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "2.4", "", 0, false}, false, latestFunc); err != nil || version != "2.4.1" {
+		t.Errorf("expected FOO:2.4 to resolve to 2.4.1, got %s (%v)", version, err)
+	}
+
+	// FOO_2.12:^2.3 searches the versions of foo_2.12
+	latestFor := func(b ServiceBinary, s string, v string) (MavenMetadata, error) {
+		if s != "2.12" {
+			t.Errorf("expected the versions of foo_2.12 to be searched, got %q", s)
+		}
+		return MavenMetadata{Artifact: "foo_2.12", Group: "org.foo", Latest: "2.3.5", Versions: []string{"2.3.0", "2.3.5"}}, nil
+	}
+	if _, artifact, version, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "^2.3", "2.12", 0, false}, false, latestFor); err != nil || artifact != "foo_2.12" || version != "2.3.5" {
+		t.Errorf("expected FOO_2.12:^2.3 to resolve to foo_2.12 2.3.5, got %s %s (%v)", artifact, version, err)
+	}
+
+	if _, _, _, err := whatVersionToRun(foo, ServiceAndVersion{"FOO", "~1.0", "", 0, false}, false, latestFunc); err == nil {
+		t.Errorf("expected an error when no version is in the range")
+	}
+}
@@ -0,0 +1,333 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sm2/cli"
+	"sm2/version"
+)
+
+const serverSocketName = "sm2.sock"
+
+// Body of the POST requests made to the server, mirrors the equivalent cli options. There's no --noprogress, the
+// server never draws progress bars since its output goes back over http.
+type serverRequest struct {
+	Services   []string            `json:"services"`
+	Release    string              `json:"release,omitempty"`
+	Offline    bool                `json:"offline,omitempty"`
+	Clean      bool                `json:"clean,omitempty"`
+	FromSource bool                `json:"src,omitempty"`
+	FromFile   string              `json:"fromFile,omitempty"` // an absolute path, the server may be running somewhere else
+	Latest     bool                `json:"latest,omitempty"`
+	Wait       int                 `json:"wait,omitempty"`
+	ExtraArgs  map[string][]string `json:"appendArgs,omitempty"`
+	Env        cli.EnvVars         `json:"env,omitempty"`
+
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	MaxRestarts   int    `json:"maxRestarts,omitempty"`
+
+	Port         int            `json:"port,omitempty"`  // for the first service, as with --port
+	ServicePorts map[string]int `json:"ports,omitempty"` // as with --port SERVICE=PORT
+	AutoPort     bool           `json:"autoPort,omitempty"`
+	PortRange    string         `json:"portRange,omitempty"`
+
+	Force       bool `json:"force,omitempty"`
+	StopTimeout int  `json:"stopTimeout,omitempty"`
+}
+
+type serverResult struct {
+	Service string `json:"service"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type serverResponse struct {
+	Results []serverResult `json:"results"`
+}
+
+type serverPing struct {
+	Version string `json:"version"`
+	Pid     int    `json:"pid"`
+}
+
+// The server keeps a single copy of the config loaded and runs commands on behalf of the cli (or anything
+// else that talks http). Commands that start or stop services are run one at a time.
+type server struct {
+	sm   *ServiceManager
+	lock sync.RWMutex
+}
+
+func (sm *ServiceManager) serverSocketPath() string {
+	return path.Join(sm.Config.TmpDir, serverSocketName)
+}
+
+// Starts the server, listening on a unix socket in the workspace and, if --port is given, on localhost too.
+func (sm *ServiceManager) StartServer() {
+
+	socketPath := sm.serverSocketPath()
+	if sm.serverClient() != nil {
+		log.Fatalf("Server: an sm2 server is already running on %s", socketPath)
+	}
+
+	// anything left over will be from a server that didn't shutdown cleanly
+	os.Remove(socketPath)
+
+	socket, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Fatalf("Server: unable to listen on %s: %s", socketPath, err)
+	}
+
+	srv := &server{sm: sm}
+	handler := srv.routes()
+
+	if sm.Commands.Supervise {
+		go newSupervisor(sm, nil, &srv.lock).run()
+	}
+
+	if sm.Commands.Port == 0 {
+		log.Printf("Server: listening on %s...", socketPath)
+		log.Fatal(http.Serve(socket, handler))
+	}
+
+	go func() {
+		log.Fatal(http.Serve(socket, handler))
+	}()
+
+	log.Printf("Server: listening on %s and localhost:%d...", socketPath, sm.Commands.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf("localhost:%d", sm.Commands.Port), handler))
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ping", s.handlePing)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /logs/{service}", s.handleLogs)
+	mux.HandleFunc("POST /verify", s.handleVerify)
+	mux.HandleFunc("POST /start", s.handleStart)
+	mux.HandleFunc("POST /stop", s.handleStop)
+	mux.HandleFunc("POST /restart", s.handleRestart)
+	return onlyLocalClients(mux)
+}
+
+// Any web page open in a browser can send requests to localhost, so only requests that a page can't make are
+// accepted: no Origin header, a localhost Host (so not via dns rebinding) and json bodies (so not a form or
+// text/plain post, which browsers send cross-origin without asking).
+func onlyLocalClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "requests from browsers aren't accepted", http.StatusForbidden)
+			return
+		}
+		if !isLocalhost(r.Host) {
+			http.Error(w, fmt.Sprintf("requests for %s aren't accepted, use localhost", r.Host), http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				http.Error(w, "requests must be sent as application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLocalhost(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// builds a copy of the service manager with the options from the request applied
+// the server's own options (--workers, --no-vpn-check etc) are used as the defaults
+func (s *server) serviceManagerFor(req serverRequest) *ServiceManager {
+	sm := *s.sm
+	sm.progress = ProgressRenderer{}
+	sm.Commands.NoProgress = true
+	sm.Commands.ExtraServices = req.Services
+	sm.Commands.Release = req.Release
+	sm.Commands.Offline = req.Offline
+	sm.Commands.Clean = req.Clean
+	sm.Commands.FromSource = req.FromSource
+	sm.Commands.FromFile = req.FromFile
+	sm.Commands.Latest = req.Latest
+	sm.Commands.ExtraArgs = req.ExtraArgs
+	sm.Commands.Env = req.Env
+	sm.Commands.Port = req.Port
+	sm.Commands.ServicePorts = req.ServicePorts
+	sm.Commands.RestartPolicy = req.RestartPolicy
+	sm.Commands.MaxRestarts = req.MaxRestarts
+	sm.Commands.AutoPort = req.AutoPort
+	sm.Commands.Force = req.Force
+	if req.PortRange != "" {
+		sm.Commands.PortRange = req.PortRange
+	}
+	if req.StopTimeout > 0 {
+		sm.Commands.StopTimeout = req.StopTimeout
+	}
+	if req.Wait > 0 {
+		sm.Commands.Wait = req.Wait
+	}
+	return &sm
+}
+
+func (s *server) handlePing(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, http.StatusOK, serverPing{Version: version.Version, Pid: os.Getpid()})
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sm := s.sm
+	statuses := []serviceStatus{sm.CheckMongo()}
+	statuses = append(statuses, sm.findStatuses()...)
+	unmanaged := []serviceStatus{}
+	if r.URL.Query().Get("noPortCheck") != "true" {
+		unmanaged = sm.findUnmanagedServices(statuses)
+	}
+	states, _ := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	proxyState := sm.Ledger.LoadProxyState(sm.Config.TmpDir)
+
+	writeJsonResponse(w, http.StatusOK, buildStatusReport(statuses, states, unmanaged, proxyState, time.Now()))
+}
+
+func (s *server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// the same filters as --lines, --grep and --since
+	query := r.URL.Query()
+	sm := *s.sm
+	sm.Commands.Lines, _ = strconv.Atoi(query.Get("lines"))
+	sm.Commands.Grep = query.Get("grep")
+	sm.Commands.Since = query.Get("since")
+	sm.Commands.Previous, _ = strconv.Atoi(query.Get("previous"))
+	opts, err := sm.logOptions(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	if err := sm.writeLogsForService(r.PathValue("service"), opts, w); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeServerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sm := s.serviceManagerFor(req)
+	results := checkIsRunning(sm.requestedServicesAndProfiles(), sm.findStatuses())
+	writeJsonResponse(w, http.StatusOK, buildVerifyReport(results))
+}
+
+func (s *server) handleStart(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeServerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sm := s.serviceManagerFor(req)
+	services := sm.requestedServicesAndProfiles()
+	if err := sm.validateFromFile(services); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	errs := sm.asyncStart(services)
+	writeJsonResponse(w, http.StatusOK, buildServerResponse(services, errs, "Started"))
+}
+
+func (s *server) handleStop(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeServerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sm := s.serviceManagerFor(req)
+	services := sm.requestedServicesAndProfiles()
+	errs := map[string]error{}
+	for _, sv := range services {
+		if err := sm.StopService(sv.service); err != nil {
+			errs[sv.service] = err
+		}
+	}
+	writeJsonResponse(w, http.StatusOK, buildServerResponse(services, errs, "Stopped"))
+}
+
+func (s *server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeServerRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sm := s.serviceManagerFor(req)
+	services := sm.requestedServicesAndProfiles()
+	errs := sm.restartServices(services)
+	writeJsonResponse(w, http.StatusOK, buildServerResponse(services, errs, "Restarted"))
+}
+
+func buildServerResponse(services []ServiceAndVersion, errs map[string]error, success string) serverResponse {
+	response := serverResponse{Results: []serverResult{}}
+	seen := map[string]bool{}
+	for _, sv := range services {
+		if seen[sv.service] {
+			continue
+		}
+		seen[sv.service] = true
+
+		result := serverResult{Service: sv.service, Ok: true, Message: success}
+		if err, failed := errs[sv.service]; failed {
+			result.Message = err.Error()
+			result.Ok = errors.Is(err, errAlreadyRunning)
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+func decodeServerRequest(w http.ResponseWriter, r *http.Request) (serverRequest, bool) {
+	req := serverRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func writeJsonResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	printJson(v, w)
+}
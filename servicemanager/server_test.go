@@ -0,0 +1,211 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestBuildServerResponse(t *testing.T) {
+	services := []ServiceAndVersion{
+		{"FOO", "", "", 0, false},
+		{"BAR", "", "", 0, false},
+		{"BAZ", "", "", 0, false},
+		{"FOO", "", "", 0, false},
+	}
+	errs := map[string]error{
+		"BAR": errors.New("health check unsuccessful after 30 seconds"),
+		"BAZ": errAlreadyRunning,
+	}
+
+	response := buildServerResponse(services, errs, "Started")
+
+	if len(response.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(response.Results))
+	}
+
+	expected := []serverResult{
+		{"FOO", true, "Started"},
+		{"BAR", false, "health check unsuccessful after 30 seconds"},
+		{"BAZ", true, "Already running"},
+	}
+	for i, e := range expected {
+		if response.Results[i] != e {
+			t.Errorf("result %d: expected %+v, got %+v", i, e, response.Results[i])
+		}
+	}
+}
+
+func TestServerVerify(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer healthy.Close()
+
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		Profiles: map[string][]string{"PROFILE": {"FOO", "BAR"}},
+		Platform: platform.Platform{
+			Uptime:    mockUptime,
+			PidLookup: mockPidLookup,
+		},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(_ string) ([]ledger.StateFile, error) {
+				return []ledger.StateFile{
+					{Service: "FOO", Pid: 9999, Started: time.Now(), HealthcheckUrl: healthy.URL},
+				}, nil
+			},
+		},
+	}
+
+	handler := (&server{sm: &sm}).routes()
+	req := httptest.NewRequest("POST", "http://localhost/verify", strings.NewReader(`{"services":["PROFILE"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	expected := `{
+  "ok": false,
+  "services": [
+    {
+      "service": "FOO",
+      "running": true
+    },
+    {
+      "service": "BAR",
+      "running": false
+    }
+  ]
+}
+`
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected response:\n%s", rec.Body.String())
+	}
+}
+
+func TestServerRejectsInvalidRequests(t *testing.T) {
+	handler := (&server{sm: &ServiceManager{}}).routes()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost/start", strings.NewReader(`not json`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 400 {
+		t.Errorf("expected 400 for invalid json, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost/start", nil))
+	if rec.Code != 405 {
+		t.Errorf("expected 405 for GET /start, got %d", rec.Code)
+	}
+}
+
+func TestServerRejectsRequestsFromBrowsers(t *testing.T) {
+	handler := (&server{sm: &ServiceManager{}}).routes()
+
+	tests := map[string]struct {
+		method      string
+		url         string
+		contentType string
+		origin      string
+		body        string // defaults to a valid request
+		code        int
+	}{
+		"text/plain post":   {method: "POST", url: "http://localhost:5999/stop", contentType: "text/plain", code: 415},
+		"form post":         {method: "POST", url: "http://localhost:5999/stop", contentType: "application/x-www-form-urlencoded", code: 415},
+		"no content type":   {method: "POST", url: "http://localhost:5999/stop", code: 415},
+		"cross origin":      {method: "POST", url: "http://localhost:5999/stop", contentType: "application/json", origin: "https://example.com", code: 403},
+		"dns rebinding":     {method: "POST", url: "http://evil.example.com:5999/stop", contentType: "application/json", code: 403},
+		"dns rebinding get": {method: "GET", url: "http://evil.example.com:5999/status", code: 403},
+		"cross origin get":  {method: "GET", url: "http://localhost:5999/ping", origin: "https://example.com", code: 403},
+		"localhost":         {method: "GET", url: "http://localhost:5999/ping", code: 200},
+		"ipv4":              {method: "GET", url: "http://127.0.0.1:5999/ping", code: 200},
+		"ipv6":              {method: "GET", url: "http://[::1]:5999/ping", code: 200},
+		"socket":            {method: "GET", url: serverSocketUrl + "/ping", code: 200},
+		"json with charset": {method: "POST", url: "http://localhost:5999/start", contentType: "application/json; charset=utf-8", body: "not json", code: 400}, // got as far as decoding it
+	}
+
+	for name, test := range tests {
+		if test.body == "" {
+			test.body = `{"services":["FOO"]}`
+		}
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%s: expected %d, got %d: %s", name, test.code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestServerRequestForwardsEveryOption(t *testing.T) {
+	client := ServiceManager{Commands: cli.UserOption{
+		ExtraServices: []string{"FOO"},
+		Release:       "1.2.3",
+		Offline:       true,
+		Clean:         true,
+		FromSource:    true,
+		FromFile:      "/tmp/foo-1.2.3.tgz",
+		Latest:        true,
+		Wait:          60,
+		ExtraArgs:     map[string][]string{"FOO": {"-Dfoo=bar"}},
+		Env:           cli.EnvVars{"FOO": {"KEY": "VALUE"}},
+		RestartPolicy: "always",
+		MaxRestarts:   3,
+		Port:          9001,
+		ServicePorts:  map[string]int{"BAR": 9002},
+		AutoPort:      true,
+		PortRange:     "30000-30999",
+		Force:         true,
+		StopTimeout:   5,
+	}}
+
+	// every field has to be set, otherwise its not being forwarded
+	req := client.serverRequest()
+	fields := reflect.ValueOf(req)
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).IsZero() {
+			t.Errorf("expected %s to be sent to the server", fields.Type().Field(i).Name)
+		}
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := serverRequest{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, req) {
+		t.Errorf("expected the request to survive being sent as json\n got: %+v\nwant: %+v", decoded, req)
+	}
+
+	s := &server{sm: &ServiceManager{Commands: cli.UserOption{StopTimeout: 10, Wait: 30, PortRange: DEFAULT_PORT_RANGE}}}
+	got := s.serviceManagerFor(decoded).Commands
+	want := client.Commands
+	want.NoProgress = true
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the server to run with the same options\n got: %+v\nwant: %+v", got, want)
+	}
+}
@@ -0,0 +1,267 @@
+package servicemanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"sm2/ledger"
+)
+
+// the host part of the url isn't used to connect over the socket, but the server only accepts requests for localhost
+const serverSocketUrl = "http://localhost"
+
+// Returns a http client connected to the sm2 server's unix socket, or nil if the server isn't running.
+func (sm *ServiceManager) serverClient() *http.Client {
+	socketPath := sm.serverSocketPath()
+	if !Exists(socketPath) {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, 250*time.Millisecond)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	return &http.Client{
+		// starting a large profile can take a while...
+		Timeout: 30 * time.Minute,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
+
+// Sends the current command to the server instead of running it locally.
+// Returns false if its not something the server handles, in which case it should be run as normal.
+func (sm *ServiceManager) runOnServer(client *http.Client) bool {
+	var err error
+
+	// a lock file can have services that run from source, which the server only does for all of them (--src)
+	if sm.Commands.FromLock != "" {
+		return false
+	}
+
+	if sm.Commands.Status || sm.Commands.StatusShort {
+		err = sm.serverStatus(client)
+	} else if sm.Commands.Start {
+		err = sm.serverCommand(client, "/start")
+	} else if sm.Commands.Stop {
+		err = sm.serverCommand(client, "/stop")
+	} else if sm.Commands.Restart {
+		err = sm.serverCommand(client, "/restart")
+	} else if sm.Commands.Verify {
+		err = sm.serverVerify(client)
+	} else if sm.Commands.Logs != "" && !sm.Commands.Follow && len(sm.logServices()) == 1 {
+		// following or combining logs is done locally, there's nothing the server can add
+		err = sm.serverLogs(client)
+	} else {
+		return false
+	}
+
+	if err != nil {
+		fmt.Printf("Error talking to the sm2 server: %s\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func (sm *ServiceManager) serverRequest() serverRequest {
+	fromFile := sm.Commands.FromFile
+	if fromFile != "" {
+		fromFile, _ = filepath.Abs(fromFile)
+	}
+	return serverRequest{
+		Services:   sm.Commands.ExtraServices,
+		Release:    sm.Commands.Release,
+		Offline:    sm.Commands.Offline,
+		Clean:      sm.Commands.Clean,
+		FromSource: sm.Commands.FromSource,
+		FromFile:   fromFile,
+		Latest:     sm.Commands.Latest,
+		Wait:       sm.Commands.Wait,
+		ExtraArgs:  sm.Commands.ExtraArgs,
+		Env:        sm.Commands.Env,
+
+		RestartPolicy: sm.Commands.RestartPolicy,
+		MaxRestarts:   sm.Commands.MaxRestarts,
+
+		Port:         sm.Commands.Port,
+		ServicePorts: sm.Commands.ServicePorts,
+		AutoPort:     sm.Commands.AutoPort,
+		PortRange:    sm.Commands.PortRange,
+
+		Force:       sm.Commands.Force,
+		StopTimeout: sm.Commands.StopTimeout,
+	}
+}
+
+func serverPost(client *http.Client, endpoint string, body interface{}, response interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(serverSocketUrl+endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeServerResponse(resp, response)
+}
+
+func serverGet(client *http.Client, endpoint string, response interface{}) error {
+	resp, err := client.Get(serverSocketUrl + endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeServerResponse(resp, response)
+}
+
+func decodeServerResponse(resp *http.Response, response interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s, %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// runs start, stop or restart on the server and prints the outcome for each service
+func (sm *ServiceManager) serverCommand(client *http.Client, endpoint string) error {
+	fmt.Println("Using the sm2 server...")
+
+	response := serverResponse{}
+	if err := serverPost(client, endpoint, sm.serverRequest(), &response); err != nil {
+		return err
+	}
+
+	failed := []serverResult{}
+	for _, result := range response.Results {
+		if result.Ok {
+			fmt.Printf("  %-40s %s\n", result.Service, result.Message)
+		} else {
+			failed = append(failed, result)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Println("\n\033[1;31mSome services failed:\033[0m")
+		for _, result := range failed {
+			fmt.Printf("  %s: %s\n", result.Service, result.Message)
+		}
+	}
+	return nil
+}
+
+func (sm *ServiceManager) serverStatus(client *http.Client) error {
+	endpoint := "/status"
+	if sm.Commands.NoPortCheck {
+		endpoint += "?noPortCheck=true"
+	}
+
+	report := jsonStatusReport{}
+	if err := serverGet(client, endpoint, &report); err != nil {
+		return err
+	}
+
+	if sm.formatJson() {
+		printJson(report, os.Stdout)
+		return nil
+	}
+
+	statuses := []serviceStatus{}
+	for _, s := range report.Services {
+		statuses = append(statuses, serviceStatus{s.Pid, s.Port, s.Service, s.Version, s.Health})
+	}
+
+	unmanaged := []serviceStatus{}
+	for _, u := range report.Unmanaged {
+		unmanaged = append(unmanaged, serviceStatus{pid: u.Pid, port: u.Port, service: u.ReservedBy})
+	}
+
+	proxyState := ledger.ProxyState{}
+	if report.ReverseProxy != nil {
+		proxyState.Pid = report.ReverseProxy.Pid
+		proxyState.Started = report.ReverseProxy.Started
+		proxyState.ProxyPaths = map[string]string{}
+		for _, route := range report.ReverseProxy.Routes {
+			proxyState.ProxyPaths[route.Path] = route.Target
+		}
+	}
+
+	sm.renderStatus(statuses, unmanaged, proxyState)
+	printRestarts(report.Services, os.Stdout)
+	return nil
+}
+
+func (sm *ServiceManager) serverVerify(client *http.Client) error {
+	report := jsonVerifyReport{}
+	if err := serverPost(client, "/verify", sm.serverRequest(), &report); err != nil {
+		return err
+	}
+
+	if sm.formatJson() {
+		printJson(report, os.Stdout)
+	} else {
+		for _, s := range report.Services {
+			if s.Running {
+				fmt.Printf("%s\tOK\n", s.Service)
+			} else {
+				fmt.Printf("%s\tMISSING\n", s.Service)
+			}
+		}
+	}
+
+	if !report.Ok {
+		os.Exit(13)
+	}
+	return nil
+}
+
+func (sm *ServiceManager) serverLogs(client *http.Client) error {
+	query := url.Values{}
+	if sm.Commands.Lines > 0 {
+		query.Set("lines", strconv.Itoa(sm.Commands.Lines))
+	}
+	if sm.Commands.Grep != "" {
+		query.Set("grep", sm.Commands.Grep)
+	}
+	if sm.Commands.Since != "" {
+		query.Set("since", sm.Commands.Since)
+	}
+	if sm.Commands.Previous > 0 {
+		query.Set("previous", strconv.Itoa(sm.Commands.Previous))
+	}
+
+	// the one service asked for, with its name normalised (or the only one in a profile)
+	service := sm.logServices()[0]
+	resp, err := client.Get(serverSocketUrl + "/logs/" + url.PathEscape(service) + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(bytes.TrimSpace(msg)))
+		return nil
+	}
+
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
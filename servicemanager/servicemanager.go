@@ -15,36 +15,51 @@ import (
 )
 
 type ServiceManager struct {
-	Client   *http.Client
-	Services map[string]Service
-	Profiles map[string][]string
-	Config   ServiceManagerConfig
-	Commands cli.UserOption
-	progress ProgressRenderer
-	Platform platform.Platform
-	Ledger   ledger.Ledger
+	Client     *http.Client
+	Services   map[string]Service
+	Profiles   map[string][]string
+	ProfileEnv map[string]map[string]string
+	Config     ServiceManagerConfig
+	Commands   cli.UserOption
+	progress   ProgressRenderer
+	Platform   platform.Platform
+	Ledger     ledger.Ledger
+	settings   []configSetting
+	ports      *portAllocator // the ports given out by --auto-port in the current batch of starts
 }
 
 type ServiceManagerConfig struct {
 	TmpDir             string
+	CacheDir           string // where downloaded tarballs are kept, see tarballCache
+	CacheSizeMb        int    // how big the tarball cache can get before old ones are removed, 0 turns it off
 	VpnTestHostname    string
 	ArtifactoryRepoUrl string
+	FallbackRepoUrls   []string // other repos to try, in order, if something isn't in ArtifactoryRepoUrl
 	ArtifactoryPingUrl string
 	ConfigDir          string
 	TimeoutShort       time.Duration
+	MetadataTtl        time.Duration  // how long maven metadata is cached before checking it again, see cachedMetadata
+	JavaHome           string         // sets JAVA_HOME for the services we start, from sm2.json
+	JavaHomes          map[int]string // JDKs to use for services that need a specific version of java, from sm2.json
+	ArtifactoryAuth    ArtifactoryAuth
 }
 
 type Service struct {
-	Id          string
-	Name        string        `json:"name"`
-	DefaultPort int           `json:"defaultPort"`
-	Template    string        `json:"template"`
-	Frontend    bool          `json:"frontend"`
-	Source      Source        `json:"sources"`
-	Binary      ServiceBinary `json:"binary"`
-	Location    string        `json:"location"`
-	Healthcheck Healthcheck   `json:"healthcheck"`
-	ProxyPaths  []string      `json:"proxyPaths"`
+	Id            string
+	Name          string            `json:"name"`
+	DefaultPort   int               `json:"defaultPort"`
+	Template      string            `json:"template"`
+	Frontend      bool              `json:"frontend"`
+	Source        Source            `json:"sources"`
+	Binary        ServiceBinary     `json:"binary"`
+	Location      string            `json:"location"`
+	Healthcheck   Healthcheck       `json:"healthcheck"`
+	ProxyPaths    []string          `json:"proxyPaths"`
+	DependsOn     []string          `json:"dependsOn"`
+	RestartPolicy string            `json:"restartPolicy"`
+	MaxRestarts   int               `json:"maxRestarts"`
+	Env           map[string]string `json:"env"`
+	JavaVersion   int               `json:"javaVersion"`
 }
 
 type ServiceBinary struct {
@@ -141,10 +156,14 @@ func (sm *ServiceManager) LoadConfig() error {
 
 	sm.Config = ServiceManagerConfig{
 		ArtifactoryRepoUrl: repoConfig.RepoUrl,
+		FallbackRepoUrls:   repoConfig.FallbackRepoUrls,
 		ArtifactoryPingUrl: repoConfig.PingUrl,
 		TmpDir:             path.Join(workspacePath, "install"),
+		CacheDir:           path.Join(workspacePath, "cache"),
+		CacheSizeMb:        DEFAULT_CACHE_SIZE_MB,
 		ConfigDir:          configPath,
 		TimeoutShort:       DEFAULT_SHORT_TIMEOUT * time.Second,
+		MetadataTtl:        DEFAULT_METADATA_TTL * time.Second,
 	}
 
 	// allow for short timout (vpn check etc) to be overriden in case of network weirdness
@@ -154,6 +173,20 @@ func (sm *ServiceManager) LoadConfig() error {
 		}
 	}
 
+	// the user's own defaults from sm2.json, cli flags and env vars take precedence
+	userConfigFile := findUserConfig(workspacePath)
+	userConfig := UserConfig{}
+	if userConfigFile != "" {
+		userConfig, err = loadUserConfig(userConfigFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load %s\n %s\n", userConfigFile, err)
+		}
+	}
+	err = sm.applyUserConfig(workspacePath, userConfigFile, userConfig)
+	if err != nil {
+		return err
+	}
+
 	// @speed consider lazy loading these rather than loading on startup
 	services, err := loadServices(configPath)
 	if err != nil {
@@ -167,6 +200,15 @@ func (sm *ServiceManager) LoadConfig() error {
 	}
 	sm.Profiles = *profiles
 
+	sm.ProfileEnv, err = loadProfileEnv(configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load profile-env.json from %s\n %s\n", configPath, err)
+	}
+	for _, name := range unknownProfileEnv(sm.Profiles, sm.ProfileEnv) {
+		// stderr, so it doesn't end up in --format json or --snapshot output
+		fmt.Fprintf(os.Stderr, "Warning: profile-env.json has env for %s, but it isn't a profile in profiles.json\n", name)
+	}
+
 	// ensure install dir exists
 	err = os.MkdirAll(sm.Config.TmpDir, 0755)
 	if err != nil {
@@ -0,0 +1,113 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+)
+
+// the version given to a tarball when it can't be worked out from its name
+const LOCAL = "local"
+
+// --from-file installs one service from a tarball, so it has to be clear which one
+func (sm *ServiceManager) validateFromFile(services []ServiceAndVersion) error {
+	if sm.Commands.FromFile == "" {
+		return nil
+	}
+	if sm.Commands.FromSource {
+		return fmt.Errorf("--from-file and --src can't be used together")
+	}
+	if len(services) != 1 {
+		return fmt.Errorf("--from-file can only be used to start one service")
+	}
+	if stat, err := os.Stat(sm.Commands.FromFile); err != nil || stat.IsDir() {
+		return fmt.Errorf("--from-file %s is not a tarball", sm.Commands.FromFile)
+	}
+	return nil
+}
+
+// Installs a locally built tarball (e.g. the output of sbt universal:packageZipTarball) the same way as one from
+// artifactory, so --status and --restart treat it like any other install. It goes in a dir of its own (e.g. 1.2.3-local)
+// so it's never mistaken for the release of the same version, see findInstalledVersion.
+func (sm *ServiceManager) installFromFile(installDir string, serviceId string, artifact string, version string, tarball string) (ledger.InstallFile, error) {
+	var installFile ledger.InstallFile
+
+	tarball, err := filepath.Abs(tarball)
+	if err != nil {
+		return installFile, err
+	}
+
+	outdir, err := versionDir(installDir, localVersionDir(version))
+	if err != nil {
+		return installFile, err
+	}
+	if err := removeExistingVersions(outdir); err != nil {
+		return installFile, err
+	}
+
+	sm.progress.update(serviceId, 0, "Init")
+
+	progressWriter := ProgressWriter{
+		service:  serviceId,
+		renderer: &sm.progress,
+	}
+
+	fileUrl := (&url.URL{Scheme: "file", Path: filepath.ToSlash(tarball)}).String()
+	serviceDir, sums, err := sm.downloadAndDecompress(fileUrl, outdir, &progressWriter)
+	if err != nil {
+		return installFile, fmt.Errorf("failed %s", err)
+	}
+
+	sum, err := treeSum(serviceDir)
+	if err != nil {
+		return installFile, err
+	}
+
+	installFile = ledger.InstallFile{
+		Service:  serviceId,
+		Artifact: artifact,
+		Version:  version,
+		Path:     serviceDir,
+		Md5Sum:   sums.md5,
+		Sha256:   sums.sha256,
+		TreeSum:  sum,
+		Repo:     fileUrl,
+		Local:    true,
+		Created:  time.Now(),
+	}
+
+	err = sm.recordInstall(installDir, installFile)
+	return installFile, err
+}
+
+// the name of the dir a local build is installed in, which is kept apart from the release of the same version
+func localVersionDir(version string) string {
+	if version == LOCAL {
+		return LOCAL
+	}
+	return version + "-" + LOCAL
+}
+
+// Works out the version of a tarball from its name, either ARTIFACT-VERSION.tgz as sbt builds them, or
+// ARTIFACT/VERSION/tgzs/ARTIFACT.tgz as they're published to ~/.ivy2/local.
+func tarballVersion(tarball string, artifact string) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(path.Base(tarball), ".tgz"), ".tar.gz")
+	artifactName := scalaSuffix.ReplaceAllLiteralString(artifact, "")
+
+	if version, found := strings.CutPrefix(name, artifactName+"-"); found && version != "" {
+		return version
+	}
+
+	dir := filepath.Dir(tarball)
+	if filepath.Base(dir) == "tgzs" {
+		return filepath.Base(filepath.Dir(dir))
+	}
+
+	return LOCAL
+}
@@ -0,0 +1,137 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+)
+
+func TestTarballVersion(t *testing.T) {
+	tests := map[string]string{
+		"target/universal/playtest-1.0.1-SNAPSHOT.tgz":                                         "1.0.1-SNAPSHOT",
+		"/tmp/playtest-1.0.0.tar.gz":                                                           "1.0.0",
+		"/home/me/.ivy2/local/uk.gov.hmrc/playtest_2.13/0.1.0-SNAPSHOT/tgzs/playtest_2.13.tgz": "0.1.0-SNAPSHOT",
+		"/tmp/something-else.tgz":                                                              LOCAL,
+	}
+	for tarball, expected := range tests {
+		if version := tarballVersion(tarball, "playtest_2.13"); version != expected {
+			t.Errorf("expected the version of %s to be %s, got %s", tarball, expected, version)
+		}
+	}
+}
+
+func TestValidateFromFile(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{FromFile: "../testing/testdata/playtest-1.0.0.tgz"}}
+	one := []ServiceAndVersion{{"PLAYTEST", "", "", 0, false}}
+
+	if err := sm.validateFromFile(one); err != nil {
+		t.Errorf("expected a single service with a tarball to be ok, got %s", err)
+	}
+	if err := sm.validateFromFile(append(one, ServiceAndVersion{"OTHER", "", "", 0, false})); err == nil {
+		t.Errorf("expected an error when starting more than one service from a file")
+	}
+
+	sm.Commands.FromFile = "../testing/testdata/missing.tgz"
+	if err := sm.validateFromFile(one); err == nil {
+		t.Errorf("expected an error when the tarball doesn't exist")
+	}
+
+	sm.Commands.FromFile = ""
+	if err := sm.validateFromFile(append(one, ServiceAndVersion{"OTHER", "", "", 0, false})); err != nil {
+		t.Errorf("expected no error without --from-file, got %s", err)
+	}
+}
+
+func TestInstallFromFile(t *testing.T) {
+	sm := ServiceManager{
+		Ledger:   ledger.NewLedger(),
+		progress: ProgressRenderer{noProgress: true},
+	}
+	installDir := t.TempDir()
+
+	installFile, err := sm.installFromFile(installDir, "PLAYTEST", "playtest", "1.0.0", "../testing/testdata/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Exists(path.Join(installFile.Path, "lib", "foo.jar")) {
+		t.Errorf("expected the tarball to be extracted to %s", installFile.Path)
+	}
+
+	saved, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil || saved.Version != "1.0.0" || !verifyInstall(saved, "PLAYTEST", "1.0.0", false) {
+		t.Errorf("expected an install file for playtest 1.0.0, got %+v (%v)", saved, err)
+	}
+	if !path.IsAbs(saved.Repo[len("file://"):]) {
+		t.Errorf("expected the install to record the full path of the tarball, got %s", saved.Repo)
+	}
+}
+
+func TestLocalBuildsAreKeptApartFromReleases(t *testing.T) {
+	sm, installDir := installVersions(t, "1.0.0")
+
+	local, err := sm.installFromFile(installDir, "PLAYTEST", "playtest", "1.0.0", "../testing/testdata/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local.Local || path.Dir(local.Path) != path.Join(installDir, "1.0.0-local") {
+		t.Errorf("expected the local build to be installed in its own dir, got %s", local.Path)
+	}
+
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+	if len(installedVersions(install)) != 2 {
+		t.Errorf("expected the release of 1.0.0 to be kept, got %v", installedVersions(install))
+	}
+	for _, offline := range []bool{false, true} {
+		found, ok := findInstalledVersion(install, "PLAYTEST", "1.0.0", offline)
+		if !ok || found.Local || found.Path != path.Join(installDir, "1.0.0", "playtest-1.0.0") {
+			t.Errorf("expected the release to be used for 1.0.0 (offline %v), got %+v", offline, found)
+		}
+	}
+
+	// offline with no version, the current install is used even though it's a local build
+	if found, ok := findInstalledVersion(install, "PLAYTEST", "", true); !ok || !found.Local {
+		t.Errorf("expected the local build to be used offline, got %+v", found)
+	}
+}
+
+func TestLocalBuildsCanBeStartedOffline(t *testing.T) {
+	sm, installDir := installVersions(t)
+
+	if _, err := sm.installFromFile(installDir, "PLAYTEST", "playtest", "1.0.0", "../testing/testdata/playtest-1.0.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+
+	if found, ok := findInstalledVersion(install, "PLAYTEST", "", true); !ok || !found.Local {
+		t.Errorf("expected the current install to be used offline, got %+v", found)
+	}
+	if _, ok := findInstalledVersion(install, "PLAYTEST", "1.0.0", true); ok {
+		t.Errorf("expected the local build not to be used as the release of 1.0.0")
+	}
+}
+
+func TestInstallFromFileWithAwkwardPath(t *testing.T) {
+	sm := ServiceManager{
+		Ledger:   ledger.NewLedger(),
+		progress: ProgressRenderer{noProgress: true},
+	}
+	tgz, err := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := path.Join(t.TempDir(), "build #2?100%")
+	os.MkdirAll(dir, 0755)
+	tarball := path.Join(dir, "playtest-1.0.0.tgz")
+	os.WriteFile(tarball, tgz, 0644)
+
+	installFile, err := sm.installFromFile(t.TempDir(), "PLAYTEST", "playtest", "1.0.0", tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Exists(path.Join(installFile.Path, "lib", "foo.jar")) {
+		t.Errorf("expected %s to be installed", tarball)
+	}
+}
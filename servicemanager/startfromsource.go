@@ -2,7 +2,6 @@ package servicemanager
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -13,8 +12,9 @@ import (
 
 const SOURCE = "source"
 
-func (sm *ServiceManager) StartFromSource(serviceName string) error {
+func (sm *ServiceManager) StartFromSource(serviceAndVersion ServiceAndVersion) error {
 
+	serviceName := serviceAndVersion.service
 	service, ok := sm.Services[serviceName]
 	if !ok {
 		return fmt.Errorf("%s is not a valid service", serviceName)
@@ -30,30 +30,46 @@ func (sm *ServiceManager) StartFromSource(serviceName string) error {
 		return err
 	}
 
-	err = sm.Ledger.SaveInstallFile(installDir, installFile)
+	err = sm.recordInstall(installDir, installFile)
 	if err != nil {
 		return err
 	}
 
 	// sbt run the service, redirect output to logs
 
+	port, err := sm.resolvePort(service, serviceAndVersion.port)
+	if err != nil {
+		return err
+	}
+
 	sm.progress.update(serviceName, 100, "Starting...")
-	state, err := sm.sbtBuildAndRun(installFile.Path, service)
+	state, err := sm.sbtBuildAndRun(installFile.Path, service, port)
 	if err != nil {
 		return err
 	}
 
+	state.RestartPolicy, state.MaxRestarts = sm.restartPolicyFor(service)
 	err = sm.Ledger.SaveStateFile(installDir, state)
-	sm.pauseTillHealthy(state.HealthcheckUrl)
-	return err
+	if err != nil {
+		return err
+	}
+	return sm.pauseTillHealthy(state)
 }
 
 func (sm *ServiceManager) installFromGit(installDir string, gitUrl string, service Service) (ledger.InstallFile, error) {
 
+	// cloned into a dir of its own, so the versions installed from artifactory are kept
+	outdir, err := versionDir(installDir, SOURCE)
+	if err != nil {
+		return ledger.InstallFile{}, err
+	}
+
 	// TODO work out if we can just git pull instead
-	removeExistingVersions(installDir)
+	if err := removeExistingVersions(outdir); err != nil {
+		return ledger.InstallFile{}, err
+	}
 
-	srcDir, err := gitClone(gitUrl, installDir)
+	srcDir, err := gitClone(gitUrl, outdir)
 	if err != nil {
 		return ledger.InstallFile{}, err
 	}
@@ -75,20 +91,27 @@ func (sm *ServiceManager) installFromGit(installDir string, gitUrl string, servi
 	return installFile, nil
 }
 
-func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service) (ledger.StateFile, error) {
+func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service, port int) (ledger.StateFile, error) {
 	state := ledger.StateFile{}
-	port := sm.findPort(service)
 
 	sbtStartCmds := "start " + fmt.Sprintf("start -Dhttp.port=%d ", port) + strings.Join(sm.generateArgs(service, "src", srcDir, append(service.Binary.Cmd[1:], service.Source.ExtraParams...)), " ")
 	args := []string{"-mem", "2048", sbtStartCmds}
 
+	env, err := sm.serviceEnv(service)
+	if err != nil {
+		return state, err
+	}
 	cmd := exec.Command("sbt", args...)
 	cmd.Dir = srcDir
+	cmd.Env = processEnv(env)
+	cmd.SysProcAttr = newProcessGroup()
 
-	logFile, err := os.Create(path.Join(srcDir, "logs", "stdout.log"))
+	logFile, err := startLogWriter(path.Join(srcDir, "logs"))
 	if err != nil {
 		return state, fmt.Errorf("unable to create stdout.log %s", err)
 	}
+	// the service and the log writer have their own copies now
+	defer logFile.Close()
 
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
@@ -98,7 +121,10 @@ func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service) (ledger.
 		return state, err
 	}
 
-	healthcheckUrl := findHealthcheckUrl(service, state.Port)
+	// reap the process if it exits while we're still running (e.g. in --serve mode) so it doesn't linger as a zombie
+	waitForExit(cmd)
+
+	healthcheckUrl := findHealthcheckUrl(service, port)
 	state = ledger.StateFile{
 		Service:        service.Id,
 		Artifact:       service.Binary.Artifact,
@@ -106,9 +132,11 @@ func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service) (ledger.
 		Path:           srcDir,
 		Started:        time.Now(),
 		Pid:            cmd.Process.Pid,
+		Pgid:           cmd.Process.Pid,
 		Port:           port,
 		Args:           args,
 		HealthcheckUrl: healthcheckUrl,
+		Env:            redactEnv(env),
 	}
 	return state, nil
 }
@@ -1,6 +1,7 @@
 package servicemanager
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -8,11 +9,14 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"sm2/ledger"
 )
 
+var errAlreadyRunning = errors.New("Already running")
+
 // startService attempts to start a version of a service, if the version is not specified
 // service manager will get the latest vesion from artifactory.
 func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) error {
@@ -27,15 +31,20 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 
 	// check if its already running and exit if it is
 	// TODO: check PID too
-	port := sm.findPort(service)
+	port, err := sm.resolvePort(service, serviceAndVersion.port)
+	if err != nil {
+		sm.progress.update(serviceAndVersion.service, 0, "Failed")
+		return err
+	}
 	healthcheckUrl := findHealthcheckUrl(service, port)
 	if sm.CheckHealth(healthcheckUrl) {
 		sm.progress.update(serviceAndVersion.service, 100, "Already running")
-		return fmt.Errorf("Already running")
+		return errAlreadyRunning
 	}
 
 	// check if we're on the VPN (if required)
-	if !sm.Commands.NoVpnCheck {
+	fromFile := sm.Commands.FromFile != ""
+	if !sm.Commands.NoVpnCheck && !fromFile {
 		vpnOk, _ := checkVpn(sm.Client, sm.Config)
 		if !offline && !vpnOk {
 			sm.progress.update(serviceAndVersion.service, 0, "No VPN")
@@ -45,19 +54,41 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 
 	// work out what we will install, where...
 	installDir, _ := sm.findInstallDirOfService(serviceAndVersion.service)
-	group, artifact, versionToInstall, err := whatVersionToRun(service, serviceAndVersion, offline, sm.GetLatestVersions)
-	if err != nil {
-		sm.progress.update(serviceAndVersion.service, 0, "Failed")
-		return err
+	var group, artifact, versionToInstall string
+	if fromFile {
+		artifact, versionToInstall = service.Binary.Artifact, serviceAndVersion.version
+		if versionToInstall == "" {
+			versionToInstall = tarballVersion(sm.Commands.FromFile, artifact)
+		}
+	} else {
+		group, artifact, versionToInstall, err = whatVersionToRun(service, serviceAndVersion, offline, sm.GetLatestVersions)
+		if err != nil {
+			sm.progress.update(serviceAndVersion.service, 0, "Failed")
+			return err
+		}
 	}
 	isInstalled := false
 	installFile, err := sm.Ledger.LoadInstallFile(installDir)
 	if err == nil {
-		isInstalled = verifyInstall(installFile, service.Id, versionToInstall, offline)
+		installed := installFile
+		installFile, isInstalled = findInstalledVersion(installed, service.Id, versionToInstall, offline)
+		if !isInstalled && offline && serviceAndVersion.version == "" {
+			// the latest version we know of isn't installed, so use whichever one is
+			installFile, isInstalled = findInstalledVersion(installed, service.Id, "", offline)
+			versionToInstall = installFile.Version
+		}
 	}
 
 	// and if required, install it...
-	if !isInstalled || sm.Commands.Clean {
+	if fromFile {
+		// always reinstall, it has probably been rebuilt since last time
+		sm.progress.update(serviceAndVersion.service, 0, "Install")
+		installFile, err = sm.installFromFile(installDir, service.Id, artifact, versionToInstall, sm.Commands.FromFile)
+		if err != nil {
+			sm.progress.update(serviceAndVersion.service, 0, "Failed")
+			return err
+		}
+	} else if !isInstalled || sm.Commands.Clean {
 
 		// if we're offline and its not installed, there's not much we can do!
 		if offline {
@@ -72,6 +103,10 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 		if err != nil {
 			return err
 		}
+	} else if err := sm.recordInstall(installDir, installFile); err != nil {
+		// its already installed, but might not be the current version
+		sm.progress.update(serviceAndVersion.service, 0, "Failed")
+		return err
 	}
 
 	// clean and recreate log dirs...
@@ -83,40 +118,55 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 
 	// start the service...
 	args := sm.generateArgs(service, versionToInstall, installFile.Path, service.Binary.Cmd[1:])
+	env, err := sm.serviceEnv(service)
+	if err != nil {
+		sm.progress.update(serviceAndVersion.service, 0, "Failed")
+		return err
+	}
 	sm.progress.update(serviceAndVersion.service, 100, "Starting...")
-	state, err := run(service, installFile, args, port)
+	state, err := run(service, installFile, args, port, env)
 	if err != nil {
 		sm.progress.update(serviceAndVersion.service, 0, "Failed")
 		return err
 	}
 	state.HealthcheckUrl = healthcheckUrl
+	state.RestartPolicy, state.MaxRestarts = sm.restartPolicyFor(service)
 	// and finally, we record out success
 	err = sm.Ledger.SaveStateFile(installDir, state)
 	if err != nil {
 		sm.progress.update(serviceAndVersion.service, 0, "Failed")
 		return err
 	}
-	err = sm.pauseTillHealthy(healthcheckUrl)
+	err = sm.pauseTillHealthy(state)
 	return err
 }
 
-func (sm *ServiceManager) pauseTillHealthy(healthcheckUrl string) error {
+// waits for a service to pass its healthcheck, giving up early if the process exits
+func (sm *ServiceManager) pauseTillHealthy(state ledger.StateFile) error {
 	count := 0
 	for count < sm.Commands.Wait*2 {
-		if sm.CheckHealth(healthcheckUrl) {
+		if sm.CheckHealth(state.HealthcheckUrl) {
 			return nil
 		}
+		if exit, ok := lookupExit(state.Pid); ok {
+			return diagnoseStartupFailure(state, "exited with "+describeExit(exit))
+		}
 		count++
 		time.Sleep(500 * time.Millisecond)
 	}
-	return fmt.Errorf("health check unsuccessful after %d seconds", sm.Commands.Wait)
+	return diagnoseStartupFailure(state, fmt.Sprintf("health check unsuccessful after %d seconds", sm.Commands.Wait))
 }
 
 func (sm *ServiceManager) installService(installDir string, serviceId string, group string, artifact string, version string) (ledger.InstallFile, error) {
 
 	var installFile ledger.InstallFile
 
-	err := removeExistingVersions(installDir)
+	// other versions are left where they are, so we can switch back to them
+	outdir, err := versionDir(installDir, version)
+	if err != nil {
+		return installFile, err
+	}
+	err = removeExistingVersions(outdir)
 	if err != nil {
 		return installFile, err
 	}
@@ -125,32 +175,67 @@ func (sm *ServiceManager) installService(installDir string, serviceId string, gr
 
 	groupPath := strings.ReplaceAll(group, ".", "/")
 	filename := fmt.Sprintf("%s-%s.tgz", url.PathEscape(artifact), url.PathEscape(version))
-	downloadUrl := sm.Config.ArtifactoryRepoUrl + path.Join("/", groupPath, url.PathEscape(artifact), url.PathEscape(version), filename)
 
 	progressWriter := ProgressWriter{
 		service:  serviceId,
 		renderer: &sm.progress,
 	}
 
-	serviceDir, err := sm.downloadAndDecompress(downloadUrl, installDir, &progressWriter)
+	var serviceDir, repo string
+	var sums checksums
+	cache := sm.tarballCache()
+
+	// try each repo in turn until one of them has it
+	for _, repo = range sm.repositories() {
+		// --clean always downloads it again, replacing the cached copy
+		if cached, ok := cache.lookup(repo, group, artifact, version); ok && !sm.Commands.Clean {
+			// we've downloaded it from this repo before
+			sm.progress.update(serviceId, 0, "Cached")
+			sums = checksums{md5: cached.Md5, sha256: cached.Sha256}
+			serviceDir, err = extractTarGzFile(cached.path, outdir)
+			break
+		}
+		downloadUrl := repo + path.Join("/", groupPath, url.PathEscape(artifact), url.PathEscape(version), filename)
+		if cache.canStore(group, artifact, version) {
+			serviceDir, sums, err = sm.downloadToCache(cache, downloadUrl, group, artifact, version, repo, outdir, &progressWriter)
+		} else {
+			serviceDir, sums, err = sm.downloadAndDecompress(downloadUrl, outdir, &progressWriter)
+		}
+		if !errors.Is(err, errNotInRepo) {
+			break
+		}
+	}
+	if errors.Is(err, errNotInRepo) {
+		return installFile, fmt.Errorf("failed %s %s was not found in %s", artifact, version, strings.Join(sm.repositories(), ", "))
+	}
 	if err != nil {
 		return installFile, fmt.Errorf("failed %s", err)
 	}
 
+	// so --verify-installs can tell if its been changed
+	sum, err := treeSum(serviceDir)
+	if err != nil {
+		return installFile, err
+	}
+
 	installFile = ledger.InstallFile{
 		Service:  serviceId,
 		Artifact: artifact,
 		Version:  version,
 		Path:     serviceDir,
+		Md5Sum:   sums.md5,
+		Sha256:   sums.sha256,
+		TreeSum:  sum,
+		Repo:     repo,
 		Created:  time.Now(),
 	}
 
-	err = sm.Ledger.SaveInstallFile(installDir, installFile)
+	err = sm.recordInstall(installDir, installFile)
 	return installFile, err
 }
 
-// Given a service (config) some args and an installFile (code) run the service.
-func run(service Service, installFile ledger.InstallFile, args []string, port int) (ledger.StateFile, error) {
+// Given a service (config) some args, extra env vars and an installFile (code) run the service.
+func run(service Service, installFile ledger.InstallFile, args []string, port int, env map[string]string) (ledger.StateFile, error) {
 
 	serviceDir := installFile.Path
 	version := installFile.Version
@@ -158,10 +243,12 @@ func run(service Service, installFile ledger.InstallFile, args []string, port in
 	// @TODO: check if pid is already running
 	removeRunningPid(serviceDir)
 
-	logFile, err := os.Create(path.Join(serviceDir, "logs", "stdout.log"))
+	logFile, err := startLogWriter(path.Join(serviceDir, "logs"))
 	if err != nil {
 		return ledger.StateFile{}, err
 	}
+	// the service and the log writer have their own copies now
+	defer logFile.Close()
 
 	// patch the port number onto the arg list
 	args = append(args, fmt.Sprintf("-Dhttp.port=%d", port))
@@ -170,14 +257,19 @@ func run(service Service, installFile ledger.InstallFile, args []string, port in
 	_, runCmd := path.Split(service.Binary.Cmd[0])
 	cmd := exec.Command(path.Join(serviceDir, "bin", runCmd), args...)
 	cmd.Dir = serviceDir
+	cmd.Env = processEnv(env)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
+	cmd.SysProcAttr = newProcessGroup()
 
 	err = cmd.Start()
 	if err != nil {
 		return ledger.StateFile{}, err
 	}
 
+	// reap the process if it exits while we're still running (e.g. in --serve mode) so it doesn't linger as a zombie
+	waitForExit(cmd)
+
 	state := ledger.StateFile{
 		Service:  service.Id,
 		Artifact: service.Binary.Artifact,
@@ -185,8 +277,10 @@ func run(service Service, installFile ledger.InstallFile, args []string, port in
 		Path:     serviceDir,
 		Started:  time.Now(),
 		Pid:      cmd.Process.Pid,
+		Pgid:     cmd.Process.Pid,
 		Port:     port,
 		Args:     args,
+		Env:      redactEnv(env),
 	}
 
 	return state, nil
@@ -199,8 +293,8 @@ func verifyInstall(installFile ledger.InstallFile, service string, version strin
 		return false
 	}
 
-	// check version (or not, if --offline)
-	if installFile.Version != version && !offline {
+	// check version (or not, if --offline and no version was asked for)
+	if installFile.Version != version && !(offline && version == "") {
 		// wrong version means a reinstall
 		return false
 	}
@@ -214,12 +308,12 @@ func verifyInstall(installFile ledger.InstallFile, service string, version strin
 	return true
 }
 
-func (sm *ServiceManager) findPort(service Service) int {
-	portNumber := service.DefaultPort
-	if sm.Commands.Port > 0 {
-		portNumber = sm.Commands.Port
+// the port a service should run on, its default port unless a different one was asked for (see ServiceAndVersion.port)
+func findPort(service Service, requestedPort int) int {
+	if requestedPort > 0 {
+		return requestedPort
 	}
-	return portNumber
+	return service.DefaultPort
 }
 
 func defaultHealthcheckUrl(port int) string {
@@ -243,6 +337,24 @@ func whatVersionToRun(service Service, serviceAndVersion ServiceAndVersion, offl
 		artifact = scalaSuffix.ReplaceAllLiteralString(artifact, "_"+serviceAndVersion.scalaVersion)
 	}
 
+	// Resolve a version range, e.g. FOO:^2.3 in a profile, to the newest version in it. For a _%% artifact only the
+	// scala version GetLatestVersions picks (the one with the newest release) is searched, FOO_2.12:^2.3 picks another.
+	if isVersionConstraint(versionToInstall) {
+		constraint, err := parseVersionConstraint(versionToInstall)
+		if err != nil {
+			return "", "", "", err
+		}
+		metadata, err := getLatest(service.Binary, serviceAndVersion.scalaVersion, "")
+		if err != nil {
+			return "", "", "", err
+		}
+		versionToInstall, err = constraint.resolve(metadata.Versions)
+		if err != nil {
+			return "", "", "", fmt.Errorf("%s: %s", service.Id, err)
+		}
+		return metadata.Group, metadata.Artifact, versionToInstall, nil
+	}
+
 	// find the scala version if reliant on _%% but requesting specific service version e.g. sm2 --start MY_SERVICE -r 1.0.0 OR sm2 --start MY_SERVICE:1.0.0
 	if serviceAndVersion.version != "" && strings.HasSuffix(artifact, "_%%") && !offline {
 		metadata, err := getLatest(service.Binary, serviceAndVersion.scalaVersion, versionToInstall)
@@ -252,14 +364,17 @@ func whatVersionToRun(service Service, serviceAndVersion ServiceAndVersion, offl
 		artifact = metadata.Artifact
 	}
 
-	if versionToInstall == "" && !offline {
+	if versionToInstall == "" {
 		metadata, err := getLatest(service.Binary, serviceAndVersion.scalaVersion, versionToInstall)
-		if err != nil {
+		if err != nil && !offline {
 			return "", "", "", err
 		}
-		group = metadata.Group
-		artifact = metadata.Artifact
-		versionToInstall = metadata.Latest
+		// offline this is the latest version we know of, if any
+		if err == nil && metadata.Latest != "" {
+			group = metadata.Group
+			artifact = metadata.Artifact
+			versionToInstall = metadata.Latest
+		}
 	}
 
 	return group, artifact, versionToInstall, nil
@@ -277,6 +392,9 @@ func (sm *ServiceManager) generateArgs(service Service, version string, serviceD
 	}
 	args = append(args, smArgs...)
 
+	// tell it where any dependencies that were given a different port are
+	args = append(args, sm.dependencyPortArgs(service)...)
+
 	// add user supplied args
 	if userArgs, ok := sm.Commands.ExtraArgs[service.Id]; ok {
 		args = append(args, userArgs...)
@@ -285,6 +403,12 @@ func (sm *ServiceManager) generateArgs(service Service, version string, serviceD
 	return args
 }
 
+// starts the service in a new process group (with the service as the group leader) so it, and anything
+// it forks, can be stopped together without relying on finding them by name
+func newProcessGroup() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
 // killing a process doesn't cleanup the RUNNING_PID preventing it being rerun
 func removeRunningPid(serviceDir string) {
 	pidPath := path.Join(serviceDir, "RUNNING_PID")
@@ -293,8 +417,8 @@ func removeRunningPid(serviceDir string) {
 	}
 }
 
-// cleans up previous installs
-// @improvement could keep n previous versions?
+// Empties a dir before something is installed into it. It deletes everything in the dir, so callers pass the dir of
+// the one version they're installing (see versionDir) rather than the service's install dir.
 func removeExistingVersions(installDir string) error {
 	if !path.IsAbs(installDir) {
 		// since we're removing a whole dir here, lets be careful that no-one has put ../../../ in the config etc
@@ -310,15 +434,18 @@ func removeExistingVersions(installDir string) error {
 }
 
 // run as a go routine to start services off a queue
-func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, wg *sync.WaitGroup) {
+// before starting a service the worker waits for any of its dependencies in the same batch to become healthy
+func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, results map[string]*startResult, wg *sync.WaitGroup) {
 
 	for task := range tasks {
 
-		var err error
-		if sm.Commands.FromSource {
-			err = sm.StartFromSource(task.service)
-		} else {
-			err = sm.StartService(task)
+		err := sm.awaitDependencies(task.service, results)
+		if err == nil {
+			if sm.Commands.FromSource || task.fromSource {
+				err = sm.StartFromSource(task)
+			} else {
+				err = sm.StartService(task)
+			}
 		}
 
 		if err != nil {
@@ -326,6 +453,14 @@ func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, wg *s
 		} else {
 			sm.progress.update(task.service, 100, "Done")
 		}
+
+		// a service that was already running is as good as one we've just started as far as its dependents are concerned
+		if result, ok := results[task.service]; ok {
+			if !errors.Is(err, errAlreadyRunning) {
+				result.err = err
+			}
+			close(result.done)
+		}
 		wg.Done()
 	}
 
@@ -335,15 +470,39 @@ func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, wg *s
 // the serviceWorkers run in concurrently, starting services as they arrive on the
 // channel. The renderer also runs concurrently, drawing input as it gets it.
 // A wait group is used to keep the app waiting for everything to finish downloading.
-func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) {
+// Services are queued in dependency order, so a service is only picked up once everything
+// it depends on has been picked up by a worker.
+// Returns the errors of any services that failed to start, keyed by service name.
+func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) map[string]error {
+
+	ordered, err := orderByDependencies(services, sm.Services)
+	if err != nil {
+		fmt.Printf("\033[1;31mUnable to start services:\033[0m %s\n", err)
+		failed := map[string]error{}
+		for _, sv := range services {
+			failed[sv.service] = err
+		}
+		return failed
+	}
+	services = ordered
+
+	// ports given out by --auto-port are only tracked for the services being started together
+	sm.ports = newPortAllocator()
 
 	// fire up the progress bar renderer
 	sm.progress.noProgress = sm.Commands.NoProgress
 	sm.progress.getTerminalSize = sm.Platform.GetTerminalSize
-	go sm.progress.renderLoop()
 	sm.progress.init(services)
+	if !sm.progress.noProgress {
+		go sm.progress.renderLoop()
+	}
 	taskQueue := make(chan ServiceAndVersion, len(services))
 
+	results := map[string]*startResult{}
+	for _, sv := range services {
+		results[sv.service] = &startResult{done: make(chan struct{})}
+	}
+
 	if len(services) == 1 {
 		// only need 1 worker if starting single service
 		sm.Commands.Workers = 1
@@ -361,9 +520,8 @@ func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) {
 	// start up a number of workers (controlled by --workers param)
 	wg := sync.WaitGroup{}
 	for i := 0; i < sm.Commands.Workers; i++ {
-		go sm.startServiceWorker(taskQueue, &wg)
+		go sm.startServiceWorker(taskQueue, results, &wg)
 	}
-
 	for _, sv := range services {
 		wg.Add(1)
 		taskQueue <- sv
@@ -377,9 +535,8 @@ func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) {
 	// if anything has failed to start, report why
 	if len(sm.progress.errors) > 0 {
 		fmt.Println("\n\033[1;31mSome services failed to start:\033[0m")
-		for k, v := range sm.progress.errors {
-			fmt.Printf("  %s: %s\n", k, v.Error())
-		}
+		printStartupErrors(sm.progress.errors, os.Stdout)
 	}
 
+	return sm.progress.errors
 }
@@ -102,7 +102,6 @@ func TestGenerateArgs(t *testing.T) {
 }
 
 func TestFindPort(t *testing.T) {
-	sm := ServiceManager{}
 	foo := Service{
 		Id:          "FOO",
 		DefaultPort: 9999,
@@ -112,13 +111,12 @@ func TestFindPort(t *testing.T) {
 	}
 
 	// test it uses the default port
-	if p := sm.findPort(foo); p != 9999 {
+	if p := findPort(foo, 0); p != 9999 {
 		t.Errorf("port %d was not default port %d", p, 9999)
 	}
 
-	// test you can override default via --port
-	sm.Commands.Port = 6666
-	if p := sm.findPort(foo); p != 6666 {
+	// test you can override default via --port (or SERVICE@PORT)
+	if p := findPort(foo, 6666); p != 6666 {
 		t.Errorf("port %d was not override port %d", p, 6666)
 	}
 }
@@ -143,10 +141,10 @@ func TestWhatVersionToRun(t *testing.T) {
 	latestFunc := func(b ServiceBinary, s string, v string) (MavenMetadata, error) {
 		return latest, nil
 	}
-	caseServiceOnly := ServiceAndVersion{"FOO", "", ""}
-	caseServiceAndVersion := ServiceAndVersion{"FOO", "1.66.0", ""}
-	caseServiceAndScalaAndVersion := ServiceAndVersion{"FOO", "1.12.0", "2.11"}
-	caseServiceAndScala := ServiceAndVersion{"FOO", "", "2.12"}
+	caseServiceOnly := ServiceAndVersion{"FOO", "", "", 0, false}
+	caseServiceAndVersion := ServiceAndVersion{"FOO", "1.66.0", "", 0, false}
+	caseServiceAndScalaAndVersion := ServiceAndVersion{"FOO", "1.12.0", "2.11", 0, false}
+	caseServiceAndScala := ServiceAndVersion{"FOO", "", "2.12", 0, false}
 
 	group, artifact, version, err := whatVersionToRun(foo, caseServiceOnly, false, latestFunc)
 	AssertNotErr(t, err)
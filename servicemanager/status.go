@@ -36,6 +36,46 @@ func (sm *ServiceManager) PrintStatus() {
 	unmanaged := []serviceStatus{}
 	proxyState := sm.Ledger.LoadProxyState(sm.Config.TmpDir)
 
+	if sm.formatJson() {
+		if !sm.Commands.NoPortCheck {
+			unmanaged = sm.findUnmanagedServices(statuses)
+		}
+		// the state files have the extra detail (start time, healthcheck) that the table doesn't show
+		states, _ := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+		printJson(buildStatusReport(statuses, states, unmanaged, proxyState, time.Now()), os.Stdout)
+		return
+	}
+
+	termWidth, _ := sm.Platform.GetTerminalSize()
+	if !sm.Commands.FormatPlain && termWidth >= 80 && !sm.Commands.NoPortCheck {
+		unmanaged = sm.findUnmanagedServices(statuses)
+	}
+	sm.renderStatus(statuses, unmanaged, proxyState)
+
+	states, _ := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	printRestarts(buildStatusReport(statuses, states, nil, ledger.ProxyState{}, time.Now()).Services, os.Stdout)
+}
+
+// lists any services the supervisor has had to restart, and why
+func printRestarts(services []jsonServiceStatus, out io.Writer) {
+	restarted := []jsonServiceStatus{}
+	for _, s := range services {
+		if s.Restarts > 0 {
+			restarted = append(restarted, s)
+		}
+	}
+	if len(restarted) == 0 {
+		return
+	}
+
+	fmt.Fprint(out, "\nThe following services have been restarted by the supervisor:\n")
+	for _, s := range restarted {
+		fmt.Fprintf(out, "  %-40s %d restart(s), last reason: %s\n", s.Service, s.Restarts, s.LastExit)
+	}
+}
+
+// draws the status tables (or plain text version if the terminal is too narrow)
+func (sm *ServiceManager) renderStatus(statuses []serviceStatus, unmanaged []serviceStatus, proxyState ledger.ProxyState) {
 	termWidth, _ := sm.Platform.GetTerminalSize()
 	if sm.Commands.FormatPlain || termWidth < 80 {
 		printPlainText(statuses, os.Stdout)
@@ -43,10 +83,6 @@ func (sm *ServiceManager) PrintStatus() {
 			printProxyPlainText(proxyState, os.Stdout)
 		}
 	} else {
-		if !sm.Commands.NoPortCheck {
-			unmanaged = sm.findUnmanagedServices(statuses)
-		}
-
 		longestServiceName := getLongestServiceName(append(statuses, unmanaged...))
 		printTable(statuses, termWidth, longestServiceName, os.Stdout)
 		printHelpIfRequired(statuses)
@@ -383,14 +419,23 @@ func (sm ServiceManager) CheckMongo() serviceStatus {
 
 func (sm *ServiceManager) VerifyAllServicesAreRunning(services []ServiceAndVersion) bool {
 	statuses := sm.findStatuses()
+	if sm.formatJson() {
+		results := checkIsRunning(services, statuses)
+		printJson(buildVerifyReport(results), os.Stdout)
+		return allRunning(results)
+	}
 	return verifyIsRunning(services, statuses)
 }
 
+type verifyResult struct {
+	service string
+	running bool
+}
+
 // For a given list of services check if they're running (i.e. in PASS state)
-// Print out the results and return a bool to indicate everything is ok
-func verifyIsRunning(services []ServiceAndVersion, statuses []serviceStatus) bool {
+func checkIsRunning(services []ServiceAndVersion, statuses []serviceStatus) []verifyResult {
 
-	allOk := true
+	results := []verifyResult{}
 	for _, service := range services {
 		found := false
 		for _, status := range statuses {
@@ -399,13 +444,33 @@ func verifyIsRunning(services []ServiceAndVersion, statuses []serviceStatus) boo
 				break
 			}
 		}
-		if found {
-			fmt.Printf("%s\tOK\n", service.service)
+		results = append(results, verifyResult{service.service, found})
+	}
+
+	return results
+}
+
+func allRunning(results []verifyResult) bool {
+	for _, r := range results {
+		if !r.running {
+			return false
+		}
+	}
+	return true
+}
+
+// For a given list of services check if they're running (i.e. in PASS state)
+// Print out the results and return a bool to indicate everything is ok
+func verifyIsRunning(services []ServiceAndVersion, statuses []serviceStatus) bool {
+
+	results := checkIsRunning(services, statuses)
+	for _, r := range results {
+		if r.running {
+			fmt.Printf("%s\tOK\n", r.service)
 		} else {
-			allOk = false
-			fmt.Printf("%s\tMISSING\n", service.service)
+			fmt.Printf("%s\tMISSING\n", r.service)
 		}
 	}
 
-	return allOk
+	return allRunning(results)
 }
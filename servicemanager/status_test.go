@@ -375,9 +375,9 @@ func TestStatuses(t *testing.T) {
 
 func TestVerifyIsRunning(t *testing.T) {
 	services := []ServiceAndVersion{
-		{"FOO", "1.0.0", "2.12"},
-		{"BAZ", "2.0.0", "2.12"},
-		{"BAR", "3.0.0", "2.12"},
+		{"FOO", "1.0.0", "2.12", 0, false},
+		{"BAZ", "2.0.0", "2.12", 0, false},
+		{"BAR", "3.0.0", "2.12", 0, false},
 	}
 
 	statuses := []serviceStatus{
@@ -1,8 +1,19 @@
 package servicemanager
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"syscall"
+	"time"
+)
+
+type stopOutcome string
+
+const (
+	STOPPED   stopOutcome = "stopped"
+	KILLED    stopOutcome = "killed (did not shutdown within the timeout)"
+	FORCED    stopOutcome = "killed"
+	NOT_FOUND stopOutcome = "not running"
 )
 
 func (sm *ServiceManager) StopService(serviceName string) error {
@@ -12,8 +23,7 @@ func (sm *ServiceManager) StopService(serviceName string) error {
 
 	for _, status := range statuses {
 		if status.service == serviceName {
-			sm.stop(status)
-			return nil
+			return sm.stop(status)
 		}
 	}
 
@@ -39,42 +49,112 @@ func (sm *ServiceManager) StopAll() {
 
 }
 
-func (sm *ServiceManager) stop(status serviceStatus) {
+func (sm *ServiceManager) stop(status serviceStatus) error {
 	serviceName := status.service
 
-	// services running from source will have been forked from the original sbt process
-	// to stop them we need to look them up by service name and stop all the associated pids
-	if status.version == SOURCE {
-		if found, pids := sm.Platform.PidLookupByService(serviceName); found {
-			fmt.Printf("Stopping %-40s (running from source)\n", serviceName)
-			for _, pid := range pids {
-				stopPid(pid)
-			}
-		} else {
+	// services started in their own process group can be stopped in one go, this includes anything they've
+	// forked (e.g. the jvm started by sbt). A negative pid signals the whole group.
+	// Services started by older versions of sm2 won't have a group and are stopped the old way.
+	var pids []int
+	if pgid := sm.findProcessGroup(serviceName); pgid > 0 {
+		fmt.Printf("Stopping %-40s(pgid %-6d)... ", serviceName, pgid)
+		pids = []int{-pgid}
+	} else if status.version == SOURCE {
+		// services running from source will have been forked from the original sbt process
+		// to stop them we need to look them up by service name and stop all the associated pids
+		found, sourcePids := sm.Platform.PidLookupByService(serviceName)
+		if !found {
 			fmt.Printf("Unable to find pid for service started from source %s.\n", serviceName)
-			return
+			return fmt.Errorf("unable to find pid for service started from source %s", serviceName)
 		}
+		fmt.Printf("Stopping %-40s (running from source)... ", serviceName)
+		pids = sourcePids
 	} else {
-		// run from release, kill the pid in the .state file
-		fmt.Printf("Stopping %-40s(pid %-7d).\n", serviceName, status.pid)
-		stopPid(status.pid)
+		// run from release, stop the pid in the .state file
+		fmt.Printf("Stopping %-40s(pid %-7d)... ", serviceName, status.pid)
+		pids = []int{status.pid}
 	}
 
+	timeout := time.Duration(sm.Commands.StopTimeout) * time.Second
+	outcome, err := stopPids(pids, timeout, sm.Commands.Force)
+	if err != nil {
+		fmt.Printf("failed, %s.\n", err)
+		return err
+	}
+	fmt.Printf("%s.\n", outcome)
+
 	// clean up service.state
 	if installDir, err := sm.findInstallDirOfService(serviceName); err == nil { // ok
 		sm.Ledger.ClearStateFile(installDir)
 	}
 
+	return nil
 }
 
-func stopPid(pid int) {
-	osProc, err := os.FindProcess(pid)
+// looks up the process group a service was started in from its .state file
+func (sm *ServiceManager) findProcessGroup(serviceName string) int {
+	installDir, err := sm.findInstallDirOfService(serviceName)
 	if err != nil {
-		fmt.Printf("PID %d does not exists.\n", pid)
+		return 0
 	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil || state.Service != serviceName {
+		return 0
+	}
+	return state.Pgid
+}
+
+// Asks the processes to shutdown with a SIGTERM, giving them up to `timeout` to exit before
+// escalating to a SIGKILL. When force is set they're killed straight away.
+// As with kill(2), a negative pid refers to every process in that process group.
+func stopPids(pids []int, timeout time.Duration, force bool) (stopOutcome, error) {
 
-	err = osProc.Kill()
+	signal := syscall.SIGTERM
+	if force {
+		signal = syscall.SIGKILL
+	}
+
+	running, err := signalPids(pids, signal)
 	if err != nil {
-		fmt.Printf("Unable to stop pid %d, %s.\n", pid, err)
+		return "", err
+	}
+
+	if len(running) == 0 {
+		return NOT_FOUND, nil
+	}
+
+	if force {
+		return FORCED, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// signal 0 doesn't do anything, but tells us if the process has gone
+		running, _ = signalPids(running, syscall.Signal(0))
+		if len(running) == 0 {
+			return STOPPED, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, err := signalPids(running, syscall.SIGKILL); err != nil {
+		return "", err
+	}
+	return KILLED, nil
+}
+
+// sends a signal to each of the pids, returning the ones that were still running
+func signalPids(pids []int, signal syscall.Signal) ([]int, error) {
+	running := []int{}
+	for _, pid := range pids {
+		err := syscall.Kill(pid, signal)
+		if errors.Is(err, syscall.ESRCH) {
+			continue
+		}
+		if err != nil {
+			return running, fmt.Errorf("unable to signal pid %d, %s", pid, err)
+		}
+		running = append(running, pid)
 	}
+	return running, nil
 }
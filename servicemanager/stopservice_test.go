@@ -0,0 +1,87 @@
+package servicemanager
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// starts a process in the background, reaping it when it exits like run() does
+func startTestProcess(t *testing.T, script string) int {
+	cmd := exec.Command("sh", "-c", script)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start test process: %s", err)
+	}
+	go cmd.Wait()
+	// give the shell a moment to set up its traps
+	time.Sleep(100 * time.Millisecond)
+	return cmd.Process.Pid
+}
+
+func TestStopPidsGracefully(t *testing.T) {
+	pid := startTestProcess(t, "exec sleep 30")
+
+	outcome, err := stopPids([]int{pid}, 5*time.Second, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if outcome != STOPPED {
+		t.Errorf("expected the process to stop gracefully, got %s", outcome)
+	}
+}
+
+func TestStopPidsEscalatesToKill(t *testing.T) {
+	pid := startTestProcess(t, "trap '' TERM; exec sleep 30")
+
+	start := time.Now()
+	outcome, err := stopPids([]int{pid}, 500*time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if outcome != KILLED {
+		t.Errorf("expected the process to be killed after the timeout, got %s", outcome)
+	}
+	if time.Since(start) < 500*time.Millisecond {
+		t.Errorf("process was killed before the timeout expired")
+	}
+}
+
+func TestStopPidsForce(t *testing.T) {
+	pid := startTestProcess(t, "trap '' TERM; exec sleep 30")
+
+	start := time.Now()
+	outcome, err := stopPids([]int{pid}, 10*time.Second, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if outcome != FORCED {
+		t.Errorf("expected the process to be killed, got %s", outcome)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("--force should not wait for the timeout")
+	}
+}
+
+func TestStopPidsStopsWholeProcessGroup(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30; wait")
+	cmd.SysProcAttr = newProcessGroup()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start test process: %s", err)
+	}
+	go cmd.Wait()
+	time.Sleep(100 * time.Millisecond)
+
+	pgid := cmd.Process.Pid
+	outcome, err := stopPids([]int{-pgid}, 5*time.Second, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if outcome != STOPPED {
+		t.Errorf("expected the process group to stop gracefully, got %s", outcome)
+	}
+
+	if running, _ := signalPids([]int{-pgid}, syscall.Signal(0)); len(running) != 0 {
+		t.Errorf("expected every process in the group to have stopped")
+	}
+}
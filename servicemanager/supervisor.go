@@ -0,0 +1,268 @@
+package servicemanager
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"sm2/ledger"
+)
+
+const (
+	RESTART_NEVER      = "never"
+	RESTART_ON_FAILURE = "on-failure"
+	RESTART_ALWAYS     = "always"
+
+	DEFAULT_MAX_RESTARTS = 5
+
+	supervisorInterval = 5 * time.Second
+	unhealthyThreshold = 3 // how many healthchecks in a row need to fail before a service is restarted
+	minBackoff         = 5 * time.Second
+	maxBackoff         = 5 * time.Minute
+)
+
+// The supervisor periodically checks the services that have a restart policy, restarting any that have
+// stopped or have stopped responding to their healthchecks.
+type supervisor struct {
+	sm          *ServiceManager
+	lock        sync.Locker     // held while checking/restarting, shared with the server when running with --serve
+	services    map[string]bool // the services to watch, if empty all services are watched
+	unhealthy   map[string]int  // consecutive failed healthchecks
+	nextAttempt map[string]time.Time
+	reported    map[int]bool // pids we've already logged giving up on, so we don't repeat ourselves
+}
+
+func newSupervisor(sm *ServiceManager, services []ServiceAndVersion, lock sync.Locker) *supervisor {
+	watching := map[string]bool{}
+	for _, s := range services {
+		watching[s.service] = true
+	}
+	return &supervisor{
+		sm:          sm,
+		lock:        lock,
+		services:    watching,
+		unhealthy:   map[string]int{},
+		nextAttempt: map[string]time.Time{},
+		reported:    map[int]bool{},
+	}
+}
+
+// Watches the given services (or everything if none are given) until sm2 is stopped.
+func (sm *ServiceManager) Supervise(services []ServiceAndVersion) {
+	newSupervisor(sm, services, &sync.Mutex{}).run()
+}
+
+func (s *supervisor) run() {
+	log.Printf("Supervisor: checking services every %v, press ctrl-c to stop", supervisorInterval)
+	for {
+		s.check(time.Now())
+		time.Sleep(supervisorInterval)
+	}
+}
+
+// works out the restart policy for a service, --restart-policy and --max-restarts take precedence over services.json
+func (sm *ServiceManager) restartPolicyFor(service Service) (string, int) {
+	policy := service.RestartPolicy
+	if sm.Commands.RestartPolicy != "" {
+		policy = sm.Commands.RestartPolicy
+	}
+	if policy != RESTART_ON_FAILURE && policy != RESTART_ALWAYS {
+		policy = RESTART_NEVER
+	}
+
+	maxRestarts := DEFAULT_MAX_RESTARTS
+	if service.MaxRestarts > 0 {
+		maxRestarts = service.MaxRestarts
+	}
+	if sm.Commands.MaxRestarts > 0 {
+		maxRestarts = sm.Commands.MaxRestarts
+	}
+	return policy, maxRestarts
+}
+
+func (s *supervisor) check(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	states, err := s.sm.Ledger.FindAllStateFiles(s.sm.Config.TmpDir)
+	if err != nil {
+		log.Printf("Supervisor: unable to read state files in %s: %s", s.sm.Config.TmpDir, err)
+		return
+	}
+	pids := s.sm.Platform.PidLookup()
+
+	for _, state := range states {
+		if len(s.services) > 0 && !s.services[state.Service] {
+			continue
+		}
+
+		// options passed to --supervise override what the service was started with
+		policy, maxRestarts := state.RestartPolicy, state.MaxRestarts
+		if s.sm.Commands.RestartPolicy != "" {
+			policy = s.sm.Commands.RestartPolicy
+		}
+		if s.sm.Commands.MaxRestarts > 0 {
+			maxRestarts = s.sm.Commands.MaxRestarts
+		}
+		if policy != RESTART_ON_FAILURE && policy != RESTART_ALWAYS {
+			continue
+		}
+
+		_, running := pids[state.Pid]
+		restart, failed, reason := s.needsRestart(state, running, now)
+		if !restart || s.reported[state.Pid] {
+			continue
+		}
+
+		if !failed && policy == RESTART_ON_FAILURE {
+			log.Printf("Supervisor: %s %s, not restarting it (policy is %s)", state.Service, reason, policy)
+			s.reported[state.Pid] = true
+			continue
+		}
+
+		if state.Restarts >= maxRestarts {
+			log.Printf("Supervisor: %s %s, giving up after %d restarts", state.Service, reason, state.Restarts)
+			s.reported[state.Pid] = true
+			continue
+		}
+
+		if now.Before(s.nextAttempt[state.Service]) {
+			continue
+		}
+
+		if running {
+			s.stopUnhealthy(state)
+		}
+
+		log.Printf("Supervisor: %s %s, restarting (attempt %d of %d)", state.Service, reason, state.Restarts+1, maxRestarts)
+		newState, err := s.sm.relaunch(state, reason)
+		if err != nil {
+			log.Printf("Supervisor: failed to restart %s: %s", state.Service, err)
+		} else {
+			log.Printf("Supervisor: %s restarted with pid %d", state.Service, newState.Pid)
+		}
+		s.unhealthy[state.Service] = 0
+		s.nextAttempt[state.Service] = now.Add(backoff(state.Restarts + 1))
+	}
+}
+
+// Works out if a service needs restarting, if it does the reason is returned along with
+// whether or not it counts as a failure (a clean exit doesn't).
+func (s *supervisor) needsRestart(state ledger.StateFile, running bool, now time.Time) (bool, bool, string) {
+
+	if !running {
+		// we only know how it exited if it was started by this instance of sm2
+		if exit, ok := lookupExit(state.Pid); ok {
+			return true, !exit.Success(), fmt.Sprintf("stopped (%s)", describeExit(exit))
+		}
+		return true, true, "stopped"
+	}
+
+	// give it chance to start up before we start checking its health
+	grace := GRACE_RELEASE
+	if state.Version == SOURCE {
+		grace = GRACE_SOURCE
+	}
+	if now.Sub(state.Started).Seconds() < grace {
+		return false, false, ""
+	}
+
+	url := state.HealthcheckUrl
+	if url == "" {
+		url = defaultHealthcheckUrl(state.Port)
+	}
+	if s.sm.CheckHealth(url) {
+		s.unhealthy[state.Service] = 0
+		return false, false, ""
+	}
+
+	s.unhealthy[state.Service]++
+	if s.unhealthy[state.Service] < unhealthyThreshold {
+		return false, false, ""
+	}
+	return true, true, fmt.Sprintf("failed %d healthchecks", s.unhealthy[state.Service])
+}
+
+func (s *supervisor) stopUnhealthy(state ledger.StateFile) {
+	pids := []int{state.Pid}
+	if state.Pgid > 0 {
+		pids = []int{-state.Pgid}
+	}
+	timeout := time.Duration(s.sm.Commands.StopTimeout) * time.Second
+	if _, err := stopPids(pids, timeout, false); err != nil {
+		log.Printf("Supervisor: failed to stop %s: %s", state.Service, err)
+	}
+}
+
+// run adds the port to the args, so drop the one from last time
+func withoutPortArg(args []string) []string {
+	withoutPort := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-Dhttp.port=") {
+			withoutPort = append(withoutPort, arg)
+		}
+	}
+	return withoutPort
+}
+
+// how long to wait before restarting a service again, doubles with every restart
+func backoff(restarts int) time.Duration {
+	wait := minBackoff
+	for i := 1; i < restarts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// starts a service again with the same version, args and port it was last started with
+// and records the restart in its .state file
+func (sm *ServiceManager) relaunch(state ledger.StateFile, lastExit string) (ledger.StateFile, error) {
+
+	service, ok := sm.Services[state.Service]
+	if !ok {
+		return state, fmt.Errorf("%s is not a service", state.Service)
+	}
+
+	installDir, _ := sm.findInstallDirOfService(state.Service)
+
+	newState, err := sm.relaunchService(service, installDir, state)
+	if err != nil {
+		// a restart that failed still counts towards the max, otherwise a service that won't start is tried forever
+		failed := state
+		failed.Restarts = state.Restarts + 1
+		failed.LastExit = fmt.Sprintf("%s, then failed to restart: %s", lastExit, err)
+		if saveErr := sm.Ledger.SaveStateFile(installDir, failed); saveErr != nil {
+			return state, fmt.Errorf("%s (and couldn't record the attempt: %s)", err, saveErr)
+		}
+		return failed, err
+	}
+
+	newState.HealthcheckUrl = state.HealthcheckUrl
+	newState.RestartPolicy = state.RestartPolicy
+	newState.MaxRestarts = state.MaxRestarts
+	newState.Restarts = state.Restarts + 1
+	newState.LastExit = lastExit
+
+	return newState, sm.Ledger.SaveStateFile(installDir, newState)
+}
+
+func (sm *ServiceManager) relaunchService(service Service, installDir string, state ledger.StateFile) (ledger.StateFile, error) {
+	if state.Version == SOURCE {
+		return sm.sbtBuildAndRun(state.Path, service, state.Port)
+	}
+
+	install, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		return state, err
+	}
+	env, err := sm.relaunchEnv(service, state)
+	if err != nil {
+		return state, err
+	}
+	return run(service, install, withoutPortArg(state.Args), state.Port, env)
+}
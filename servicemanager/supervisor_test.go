@@ -0,0 +1,167 @@
+package servicemanager
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		restarts int
+		expected time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := backoff(test.restarts); got != test.expected {
+			t.Errorf("backoff(%d) = %v, expected %v", test.restarts, got, test.expected)
+		}
+	}
+}
+
+func TestRestartPolicyFor(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{}}
+
+	policy, max := sm.restartPolicyFor(Service{})
+	if policy != RESTART_NEVER || max != DEFAULT_MAX_RESTARTS {
+		t.Errorf("expected defaults, got %s %d", policy, max)
+	}
+
+	policy, max = sm.restartPolicyFor(Service{RestartPolicy: "on-failure", MaxRestarts: 2})
+	if policy != RESTART_ON_FAILURE || max != 2 {
+		t.Errorf("expected config to be used, got %s %d", policy, max)
+	}
+
+	policy, _ = sm.restartPolicyFor(Service{RestartPolicy: "sometimes"})
+	if policy != RESTART_NEVER {
+		t.Errorf("expected unknown policies to be treated as never, got %s", policy)
+	}
+
+	sm.Commands.RestartPolicy = "always"
+	sm.Commands.MaxRestarts = 9
+	policy, max = sm.restartPolicyFor(Service{RestartPolicy: "on-failure", MaxRestarts: 2})
+	if policy != RESTART_ALWAYS || max != 9 {
+		t.Errorf("expected cli options to override config, got %s %d", policy, max)
+	}
+}
+
+func TestNeedsRestartUsesExitStatus(t *testing.T) {
+	s := newSupervisor(&ServiceManager{}, nil, &sync.Mutex{})
+
+	failing := exec.Command("sh", "-c", "exit 3")
+	if err := failing.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitForExit(failing)
+
+	clean := exec.Command("sh", "-c", "exit 0")
+	if err := clean.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitForExit(clean)
+
+	time.Sleep(200 * time.Millisecond)
+
+	restart, failed, reason := s.needsRestart(ledger.StateFile{Service: "A", Pid: failing.Process.Pid}, false, time.Now())
+	if !restart || !failed || reason != "stopped (exit code 3)" {
+		t.Errorf("expected a failure, got %v %v %s", restart, failed, reason)
+	}
+
+	restart, failed, reason = s.needsRestart(ledger.StateFile{Service: "B", Pid: clean.Process.Pid}, false, time.Now())
+	if !restart || failed || reason != "stopped (exit code 0)" {
+		t.Errorf("expected a clean exit, got %v %v %s", restart, failed, reason)
+	}
+}
+
+func TestSupervisorLeavesServicesAlone(t *testing.T) {
+	states := []ledger.StateFile{
+		{Service: "NO_POLICY", Pid: 1001},
+		{Service: "NEVER", Pid: 1002, RestartPolicy: RESTART_NEVER},
+		{Service: "GIVEN_UP", Pid: 1003, RestartPolicy: RESTART_ALWAYS, MaxRestarts: 2, Restarts: 2},
+		{Service: "NOT_WATCHED", Pid: 1004, RestartPolicy: RESTART_ALWAYS, MaxRestarts: 2},
+	}
+
+	saved := []string{}
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{TmpDir: "/tmp"},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{} },
+		},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(_ string) ([]ledger.StateFile, error) { return states, nil },
+			SaveStateFile: func(_ string, state ledger.StateFile) error {
+				saved = append(saved, state.Service)
+				return nil
+			},
+		},
+	}
+
+	watching := []ServiceAndVersion{{"NO_POLICY", "", "", 0, false}, {"NEVER", "", "", 0, false}, {"GIVEN_UP", "", "", 0, false}}
+	s := newSupervisor(&sm, watching, &sync.Mutex{})
+	s.check(time.Now())
+
+	if len(saved) > 0 {
+		t.Errorf("expected nothing to be restarted, got %v", saved)
+	}
+	if !s.reported[1003] {
+		t.Errorf("expected GIVEN_UP to be reported as given up on")
+	}
+}
+
+func TestSupervisorGivesUpOnServicesThatFailToRestart(t *testing.T) {
+	states := map[string]ledger.StateFile{
+		"FOO": {Service: "FOO", Version: "1.0.0", Pid: 1001, RestartPolicy: RESTART_ALWAYS, MaxRestarts: 2},
+	}
+
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: "/tmp"},
+		Services: map[string]Service{"FOO": {Id: "FOO", Binary: ServiceBinary{DestinationSubdir: "foo"}}},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{} },
+		},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(_ string) ([]ledger.StateFile, error) {
+				return []ledger.StateFile{states["FOO"]}, nil
+			},
+			SaveStateFile: func(_ string, state ledger.StateFile) error {
+				states[state.Service] = state
+				return nil
+			},
+			LoadInstallFile: func(_ string) (ledger.InstallFile, error) {
+				return ledger.InstallFile{}, errors.New("not installed")
+			},
+		},
+	}
+
+	s := newSupervisor(&sm, nil, &sync.Mutex{})
+	now := time.Now()
+	for i := 1; i <= 2; i++ {
+		s.check(now)
+		if states["FOO"].Restarts != i {
+			t.Fatalf("expected failed attempt %d to be recorded, got %d restarts", i, states["FOO"].Restarts)
+		}
+		now = now.Add(maxBackoff)
+	}
+
+	if lastExit := states["FOO"].LastExit; !strings.Contains(lastExit, "stopped") || !strings.Contains(lastExit, "not installed") {
+		t.Errorf("expected the last exit and the failure to be recorded, got %q", lastExit)
+	}
+
+	s.check(now)
+	if states["FOO"].Restarts != 2 || !s.reported[1001] {
+		t.Errorf("expected the supervisor to give up after 2 attempts, got %d restarts", states["FOO"].Restarts)
+	}
+}
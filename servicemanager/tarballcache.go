@@ -0,0 +1,215 @@
+package servicemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DEFAULT_CACHE_SIZE_MB = 2048
+
+// The tarballs downloaded for each version of a service, so switching back and forth between versions doesn't mean
+// downloading them again. They're kept under $WORKSPACE/cache/REPO/GROUP/ARTIFACT/VERSION along with their checksums,
+// where REPO is a hash of the repo they came from, so a tarball from one repo is never used in place of another's.
+// When it gets bigger than its limit the least recently used ones are removed.
+type tarballCache struct {
+	dir     string
+	maxSize int64 // in bytes, 0 turns the cache off
+}
+
+// a tarball in the cache, the exported fields are saved alongside it
+type cachedTarball struct {
+	Sha256 string `json:"sha256"`
+	Md5    string `json:"md5"`
+	Repo   string `json:"repo"`
+
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+func (sm *ServiceManager) tarballCache() tarballCache {
+	return tarballCache{dir: sm.Config.CacheDir, maxSize: int64(sm.Config.CacheSizeMb) * 1024 * 1024}
+}
+
+// checks the cache is turned on and the key is safe to use as a path, e.g. that the version isn't ../../something
+func (c tarballCache) canStore(group string, artifact string, version string) bool {
+	if c.dir == "" || c.maxSize <= 0 || len(groupParts(group)) == 0 {
+		return false
+	}
+	for _, part := range []string{artifact, version} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// groups are usually uk.gov.hmrc, but sometimes uk/gov/hmrc
+func groupParts(group string) []string {
+	return strings.FieldsFunc(group, func(r rune) bool { return r == '.' || r == '/' })
+}
+
+func (c tarballCache) pathFor(repo string, group string, artifact string, version string) string {
+	sum := sha256.Sum256([]byte(repo))
+	groupPath := filepath.Join(groupParts(group)...)
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8]), groupPath, artifact, version, fmt.Sprintf("%s-%s.tgz", artifact, version))
+}
+
+// Finds a version of a service that was downloaded from a repo in the cache, checking it hasn't been corrupted since
+// it was added. Anything that has is removed so it gets downloaded again.
+func (c tarballCache) lookup(repo string, group string, artifact string, version string) (cachedTarball, bool) {
+	if !c.canStore(group, artifact, version) {
+		return cachedTarball{}, false
+	}
+	tarball := c.pathFor(repo, group, artifact, version)
+
+	cached := cachedTarball{}
+	meta, err := os.ReadFile(tarball + ".json")
+	if err != nil || json.Unmarshal(meta, &cached) != nil {
+		return cachedTarball{}, false
+	}
+
+	sum, err := sha256File(tarball)
+	if err != nil || sum != cached.Sha256 {
+		c.remove(tarball)
+		return cachedTarball{}, false
+	}
+
+	// for the LRU
+	now := time.Now()
+	os.Chtimes(tarball, now, now)
+
+	cached.path = tarball
+	return cached, true
+}
+
+// Moves a verified download into the cache, replacing any copy that's already there and making room for it if need
+// be. Returns where its been put.
+func (c tarballCache) add(repo string, group string, artifact string, version string, download string, sums checksums) (string, error) {
+	if !c.canStore(group, artifact, version) {
+		return "", fmt.Errorf("%s %s %s can't be cached", group, artifact, version)
+	}
+	tarball := c.pathFor(repo, group, artifact, version)
+	if err := os.MkdirAll(filepath.Dir(tarball), 0755); err != nil {
+		return "", err
+	}
+
+	meta, err := json.Marshal(cachedTarball{Sha256: sums.sha256, Md5: sums.md5, Repo: repo})
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(download, tarball); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(tarball+".json", meta, 0644); err != nil {
+		return "", err
+	}
+
+	c.evict(tarball)
+	return tarball, nil
+}
+
+// removes the least recently used tarballs until the cache is within its limit, apart from the one to keep
+func (c tarballCache) evict(keep string) {
+	entries, err := c.entries()
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for _, e := range entries {
+		if total <= c.maxSize {
+			break
+		}
+		if e.path == keep {
+			continue
+		}
+		c.remove(e.path)
+		total -= e.size
+	}
+}
+
+func (c tarballCache) remove(tarball string) error {
+	if err := os.Remove(tarball); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(tarball + ".json")
+	// tidy up the dirs it was in, which only works while they're empty
+	for dir := filepath.Dir(tarball); strings.HasPrefix(dir, c.dir+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// everything in the cache
+func (c tarballCache) entries() ([]cachedTarball, error) {
+	entries := []cachedTarball{}
+	if c.dir == "" {
+		return entries, nil
+	}
+	err := filepath.WalkDir(c.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(file, ".tgz") || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, cachedTarball{path: file, size: info.Size(), lastUsed: info.ModTime()})
+		return nil
+	})
+	return entries, err
+}
+
+// Downloads a tarball into the cache and extracts it from there. Downloads are made in the cache dir so they can
+// be moved into place once they've been verified.
+func (sm *ServiceManager) downloadToCache(c tarballCache, url string, group string, artifact string, version string, repo string, outdir string, progressWriter *ProgressWriter) (string, checksums, error) {
+	download, sums, err := sm.downloadVerified(url, c.dir, progressWriter)
+	if err != nil {
+		return "", checksums{}, err
+	}
+
+	tarball, err := c.add(repo, group, artifact, version, download, sums)
+	if err != nil {
+		os.Remove(download)
+		return "", checksums{}, err
+	}
+
+	serviceDir, err := extractTarGzFile(tarball, outdir)
+	return serviceDir, sums, err
+}
+
+func sha256File(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,211 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// puts a fake download of the given size into the cache
+func addToCache(t *testing.T, c tarballCache, artifact string, version string, size int) string {
+	t.Helper()
+	download := path.Join(c.dir, ".download")
+	os.MkdirAll(c.dir, 0755)
+	content := []byte(strings.Repeat("x", size))
+	os.WriteFile(download, content, 0644)
+	sums, _ := hashFile(strings.NewReader(string(content)))
+	tarball, err := c.add("https://repo", "uk.gov.hmrc", artifact, version, download, sums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tarball
+}
+
+func TestTarballCacheAddAndLookup(t *testing.T) {
+	c := tarballCache{dir: t.TempDir(), maxSize: 1024}
+	tarball := addToCache(t, c, "foo", "1.0.0", 10)
+
+	if !strings.HasPrefix(tarball, c.dir) || !strings.HasSuffix(tarball, path.Join("uk", "gov", "hmrc", "foo", "1.0.0", "foo-1.0.0.tgz")) {
+		t.Errorf("unexpected cache path %s", tarball)
+	}
+	cached, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "1.0.0")
+	if !ok || cached.path != tarball || cached.Repo != "https://repo" || cached.Sha256 == "" {
+		t.Errorf("expected foo 1.0.0 to be in the cache, got %+v", cached)
+	}
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "2.0.0"); ok {
+		t.Errorf("didn't expect foo 2.0.0 to be in the cache")
+	}
+	if _, ok := c.lookup("https://other-repo", "uk.gov.hmrc", "foo", "1.0.0"); ok {
+		t.Errorf("didn't expect foo 1.0.0 from another repo to be in the cache")
+	}
+}
+
+func TestTarballCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := tarballCache{dir: t.TempDir(), maxSize: 25}
+	oldest := addToCache(t, c, "foo", "1.0.0", 10)
+	used := addToCache(t, c, "foo", "2.0.0", 10)
+	longAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(oldest, longAgo, longAgo)
+	os.Chtimes(used, longAgo.Add(time.Minute), longAgo.Add(time.Minute))
+
+	// using it makes it the most recent
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "2.0.0"); !ok {
+		t.Fatal("expected foo 2.0.0 to be in the cache")
+	}
+	addToCache(t, c, "bar", "1.0.0", 10)
+
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "1.0.0"); ok {
+		t.Errorf("expected the least recently used tarball to have been evicted")
+	}
+	if Exists(path.Dir(oldest)) {
+		t.Errorf("expected the evicted tarball's dir to be removed")
+	}
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "2.0.0"); !ok {
+		t.Errorf("expected the recently used tarball to still be in the cache")
+	}
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "bar", "1.0.0"); !ok {
+		t.Errorf("expected the new tarball to be in the cache")
+	}
+}
+
+func TestTarballCacheRemovesCorruptEntries(t *testing.T) {
+	c := tarballCache{dir: t.TempDir(), maxSize: 1024}
+	tarball := addToCache(t, c, "foo", "1.0.0", 10)
+	os.WriteFile(tarball, []byte("corrupted"), 0644)
+
+	if _, ok := c.lookup("https://repo", "uk.gov.hmrc", "foo", "1.0.0"); ok {
+		t.Errorf("expected a corrupt tarball not to be used")
+	}
+	if Exists(tarball) || Exists(tarball+".json") {
+		t.Errorf("expected the corrupt tarball to be removed from the cache")
+	}
+}
+
+func TestTarballCacheRejectsUnsafeKeys(t *testing.T) {
+	c := tarballCache{dir: t.TempDir(), maxSize: 1024}
+	for _, key := range [][]string{
+		{"uk.gov.hmrc", "foo", "../../evil"},
+		{"uk.gov.hmrc", "..", "1.0.0"},
+		{"uk.gov.hmrc", "foo", ""},
+		{"..", "foo", "1.0.0"},
+	} {
+		if c.canStore(key[0], key[1], key[2]) {
+			t.Errorf("expected %v not to be cacheable", key)
+		}
+	}
+	if !c.canStore("com/example", "foo", "1.0.0") {
+		t.Errorf("expected groups with slashes to be cacheable")
+	}
+	if (tarballCache{dir: c.dir, maxSize: 0}).canStore("uk.gov.hmrc", "foo", "1.0.0") {
+		t.Errorf("expected a cache size of 0 to turn the cache off")
+	}
+}
+
+func TestInstallServiceUsesTheCache(t *testing.T) {
+	var downloads atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "playtest-1.0.0.tgz") {
+			w.WriteHeader(404)
+			return
+		}
+		downloads.Add(1)
+		http.ServeFile(w, r, "../testing/testdata/playtest-1.0.0.tgz")
+	}))
+	defer svr.Close()
+
+	sm := repoServiceManager(t, svr.URL)
+	sm.Config.TmpDir = t.TempDir()
+	sm.Config.CacheDir = t.TempDir()
+	sm.Config.CacheSizeMb = DEFAULT_CACHE_SIZE_MB
+
+	installDir := path.Join(sm.Config.TmpDir, "playtest")
+	first, err := sm.installService(installDir, "PLAY_TEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sm.installService(installDir, "PLAY_TEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if downloads.Load() != 1 {
+		t.Errorf("expected the second install to come from the cache, got %d downloads", downloads.Load())
+	}
+	if second.Repo != svr.URL || second.Sha256 != first.Sha256 || second.TreeSum != first.TreeSum {
+		t.Errorf("expected the cached install to match the downloaded one, got %+v and %+v", first, second)
+	}
+	if !Exists(path.Join(second.Path, "bin", "playtest")) {
+		t.Errorf("expected the service to be extracted from the cache")
+	}
+
+	// --clean downloads it again
+	sm.Commands.Clean = true
+	if _, err := sm.installService(installDir, "PLAY_TEST", "foo.bar", "playtest", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if downloads.Load() != 2 {
+		t.Errorf("expected --clean to download it again, got %d downloads", downloads.Load())
+	}
+}
+
+func TestInstallServiceDoesntUseTarballsCachedFromAnotherRepo(t *testing.T) {
+	tgz, err := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloads atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".tgz") {
+			w.WriteHeader(404)
+			return
+		}
+		downloads.Add(1)
+		w.Write(tgz)
+	}))
+	defer svr.Close()
+
+	sm := repoServiceManager(t, svr.URL)
+	sm.Config.TmpDir = t.TempDir()
+	sm.Config.CacheDir = t.TempDir()
+	sm.Config.CacheSizeMb = DEFAULT_CACHE_SIZE_MB
+
+	// cached from a fallback repo
+	download := path.Join(sm.Config.CacheDir, "download.tgz")
+	os.WriteFile(download, tgz, 0644)
+	sum, _ := sha256File(download)
+	if _, err := sm.tarballCache().add("file:///fallback", "foo.bar", "playtest", "1.0.0", download, checksums{sha256: sum}); err != nil {
+		t.Fatal(err)
+	}
+
+	installFile, err := sm.installService(path.Join(sm.Config.TmpDir, "playtest"), "PLAY_TEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloads.Load() != 1 || installFile.Repo != svr.URL {
+		t.Errorf("expected it to be downloaded from %s, got %d downloads from %s", svr.URL, downloads.Load(), installFile.Repo)
+	}
+}
+
+func TestCleanCacheRemovesTarballs(t *testing.T) {
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{
+			TmpDir:      t.TempDir(),
+			CacheDir:    path.Join(t.TempDir(), "cache"),
+			CacheSizeMb: DEFAULT_CACHE_SIZE_MB,
+		},
+	}
+	sm.Commands.Verbose = true // Skip confirmation prompt
+	tarball := addToCache(t, sm.tarballCache(), "foo", "1.0.0", 10)
+
+	if err := sm.CleanCache(); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(tarball) {
+		t.Errorf("expected the cached tarball to be deleted")
+	}
+}
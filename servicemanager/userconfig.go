@@ -0,0 +1,278 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sm2/cli"
+)
+
+const userConfigFileName = "sm2.json"
+
+// The user's own defaults, loaded from $WORKSPACE/sm2.json (or ~/.sm2/sm2.json). Anything that isn't set is
+// left as nil/empty so the usual defaults are used. Cli flags and env vars take precedence over these.
+type UserConfig struct {
+	Workers            *int              `json:"workers"`
+	Wait               *int              `json:"wait"`
+	TimeoutShort       *int              `json:"timeoutShort"` // in seconds
+	MetadataTtl        *int              `json:"metadataTtl"`  // in seconds
+	ArtifactoryRepoUrl string            `json:"artifactoryRepoUrl"`
+	ArtifactoryPingUrl string            `json:"artifactoryPingUrl"`
+	FallbackRepos      []string          `json:"fallbackRepos"` // other repos to try in order, e.g. snapshots or file:///home/me/.m2/repository
+	VpnCheck           *bool             `json:"vpnCheck"`
+	NoProgress         *bool             `json:"noProgress"`
+	InstallDir         string            `json:"installDir"`
+	CacheDir           string            `json:"cacheDir"`
+	CacheSizeMb        *int              `json:"cacheSizeMb"` // 0 turns off the tarball cache
+	PortRange          string            `json:"portRange"`   // the ports --auto-port picks from, e.g. "20000-20999"
+	JavaHome           string            `json:"javaHome"`
+	JavaHomes          map[string]string `json:"javaHomes"` // major version -> JDK, e.g. {"21": "/usr/lib/jvm/java-21"}
+	Artifactory        *ArtifactoryAuth  `json:"artifactory"`
+}
+
+// an effective setting and where it came from, shown by --show-config
+type configSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// looks for sm2.json in the workspace, then in the default workspace (~/.sm2). Returns "" if there isn't one.
+func findUserConfig(workspacePath string) string {
+	candidates := []string{path.Join(workspacePath, userConfigFileName)}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, path.Join(homeDir, DEFAULT_WORKSPACE, userConfigFileName))
+	}
+
+	for _, candidate := range candidates {
+		if Exists(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func loadUserConfig(configFile string) (UserConfig, error) {
+	userConfig := UserConfig{}
+
+	file, err := os.Open(configFile)
+	if err != nil {
+		return userConfig, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&userConfig)
+	return userConfig, err
+}
+
+// where a setting came from if it wasn't sm2.json: a cli flag, an env var or the default
+func settingSource(opts cli.UserOption, flagName string, envVar string) string {
+	if flagName != "" && opts.ExplicitFlags[flagName] {
+		return "--" + flagName
+	}
+	if envVar != "" {
+		if _, isSet := os.LookupEnv(envVar); isSet {
+			return envVar
+		}
+	}
+	return "default"
+}
+
+// Applies the settings in sm2.json to anything that hasn't been set by a cli flag or env var, recording
+// where each setting came from.
+func (sm *ServiceManager) applyUserConfig(workspacePath string, configFile string, userConfig UserConfig) error {
+
+	sm.settings = []configSetting{}
+	add := func(name string, value string, source string) {
+		sm.settings = append(sm.settings, configSetting{Name: name, Value: value, Source: source})
+	}
+
+	source := settingSource(sm.Commands, "", "WORKSPACE")
+	add("workspace", workspacePath, source)
+
+	source = settingSource(sm.Commands, "config", "")
+	add("config", sm.Config.ConfigDir, source)
+
+	source = "default"
+	if userConfig.InstallDir != "" {
+		if !path.IsAbs(userConfig.InstallDir) {
+			return fmt.Errorf("Config issue! installDir in %s must be an absolute path\n", configFile)
+		}
+		sm.Config.TmpDir = userConfig.InstallDir
+		source = configFile
+	}
+	add("installDir", sm.Config.TmpDir, source)
+
+	source = "default"
+	if userConfig.CacheDir != "" {
+		if !path.IsAbs(userConfig.CacheDir) {
+			return fmt.Errorf("Config issue! cacheDir in %s must be an absolute path\n", configFile)
+		}
+		sm.Config.CacheDir = userConfig.CacheDir
+		source = configFile
+	}
+	add("cacheDir", sm.Config.CacheDir, source)
+
+	source = "default"
+	if userConfig.CacheSizeMb != nil {
+		if *userConfig.CacheSizeMb < 0 {
+			return fmt.Errorf("Config issue! cacheSizeMb in %s must be >= 0\n", configFile)
+		}
+		sm.Config.CacheSizeMb = *userConfig.CacheSizeMb
+		source = configFile
+	}
+	add("cacheSizeMb", strconv.Itoa(sm.Config.CacheSizeMb), source)
+
+	source = settingSource(sm.Commands, "workers", "SM_WORKERS")
+	if source == "default" && userConfig.Workers != nil {
+		if *userConfig.Workers <= 0 {
+			return fmt.Errorf("Config issue! workers in %s must be > 0\n", configFile)
+		}
+		sm.Commands.Workers = *userConfig.Workers
+		source = configFile
+	}
+	add("workers", strconv.Itoa(sm.Commands.Workers), source)
+
+	source = settingSource(sm.Commands, "wait", "")
+	if source == "default" && userConfig.Wait != nil {
+		sm.Commands.Wait = *userConfig.Wait
+		source = configFile
+	}
+	add("wait", strconv.Itoa(sm.Commands.Wait), source)
+
+	source = settingSource(sm.Commands, "", "SM_TIMEOUT")
+	if source == "default" && userConfig.TimeoutShort != nil {
+		sm.Config.TimeoutShort = time.Duration(*userConfig.TimeoutShort) * time.Second
+		source = configFile
+	}
+	add("timeoutShort", sm.Config.TimeoutShort.String(), source)
+
+	source = "default"
+	if userConfig.MetadataTtl != nil {
+		if *userConfig.MetadataTtl < 0 {
+			return fmt.Errorf("Config issue! metadataTtl in %s must be >= 0\n", configFile)
+		}
+		sm.Config.MetadataTtl = time.Duration(*userConfig.MetadataTtl) * time.Second
+		source = configFile
+	}
+	add("metadataTtl", sm.Config.MetadataTtl.String(), source)
+
+	source = settingSource(sm.Commands, "no-vpn-check", "SM_NOVPN")
+	if source == "default" && userConfig.VpnCheck != nil {
+		sm.Commands.NoVpnCheck = !*userConfig.VpnCheck
+		source = configFile
+	}
+	add("vpnCheck", strconv.FormatBool(!sm.Commands.NoVpnCheck), source)
+
+	source = settingSource(sm.Commands, "noprogress", "")
+	if source == "default" && userConfig.NoProgress != nil {
+		sm.Commands.NoProgress = *userConfig.NoProgress
+		source = configFile
+	}
+	add("noProgress", strconv.FormatBool(sm.Commands.NoProgress), source)
+
+	source = settingSource(sm.Commands, "port-range", "")
+	if source == "default" && userConfig.PortRange != "" {
+		sm.Commands.PortRange = userConfig.PortRange
+		source = configFile
+	}
+	if sm.Commands.PortRange == "" {
+		sm.Commands.PortRange = DEFAULT_PORT_RANGE
+	}
+	if _, _, err := parsePortRange(sm.Commands.PortRange); err != nil {
+		return fmt.Errorf("Config issue! %s (from %s)\n", err, source)
+	}
+	add("portRange", sm.Commands.PortRange, source)
+
+	repoSource := "default"
+	if Exists(path.Join(sm.Config.ConfigDir, "config.json")) {
+		repoSource = path.Join(sm.Config.ConfigDir, "config.json")
+	}
+
+	source = repoSource
+	if userConfig.ArtifactoryRepoUrl != "" {
+		sm.Config.ArtifactoryRepoUrl = strings.TrimSuffix(userConfig.ArtifactoryRepoUrl, "/")
+		source = configFile
+	}
+	add("artifactoryRepoUrl", sm.Config.ArtifactoryRepoUrl, source)
+
+	source = repoSource
+	if userConfig.ArtifactoryPingUrl != "" {
+		sm.Config.ArtifactoryPingUrl = userConfig.ArtifactoryPingUrl
+		source = configFile
+	}
+	add("artifactoryPingUrl", sm.Config.ArtifactoryPingUrl, source)
+
+	source = repoSource
+	if userConfig.FallbackRepos != nil {
+		sm.Config.FallbackRepoUrls = []string{}
+		for _, repo := range userConfig.FallbackRepos {
+			sm.Config.FallbackRepoUrls = append(sm.Config.FallbackRepoUrls, strings.TrimSuffix(repo, "/"))
+		}
+		source = configFile
+	}
+	add("fallbackRepos", strings.Join(sm.Config.FallbackRepoUrls, ", "), source)
+
+	sm.Config.ArtifactoryAuth = findArtifactoryAuth(configFile, userConfig.Artifactory, sm.Config.ArtifactoryRepoUrl)
+	source = sm.Config.ArtifactoryAuth.source
+	if source == "" {
+		source = "default"
+	}
+	add("artifactoryAuth", sm.Config.ArtifactoryAuth.describe(), source)
+
+	source = settingSource(sm.Commands, "", "JAVA_HOME")
+	javaHome := os.Getenv("JAVA_HOME")
+	if userConfig.JavaHome != "" {
+		sm.Config.JavaHome = userConfig.JavaHome
+		javaHome = userConfig.JavaHome
+		source = configFile
+	}
+	add("javaHome", javaHome, source)
+
+	sm.Config.JavaHomes = map[int]string{}
+	versions := []string{}
+	for version := range userConfig.JavaHomes {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		home := userConfig.JavaHomes[version]
+		major, err := strconv.Atoi(version)
+		if err != nil {
+			return fmt.Errorf("Config issue! javaHomes in %s should be keyed by major version, e.g. \"21\", not %s\n", configFile, version)
+		}
+		sm.Config.JavaHomes[major] = home
+		add("javaHomes."+version, home, configFile)
+	}
+
+	return nil
+}
+
+// prints the effective settings and where they came from
+func (sm *ServiceManager) ShowConfig(out io.Writer) {
+	if sm.formatJson() {
+		printJson(sm.settings, out)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tFROM")
+	for _, s := range sm.settings {
+		value := s.Value
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, value, s.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,122 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestApplyUserConfig(t *testing.T) {
+	sm := ServiceManager{
+		Commands: cli.UserOption{Workers: 2, Wait: 30, ExplicitFlags: map[string]bool{}},
+		Config:   ServiceManagerConfig{TmpDir: "/ws/install", ArtifactoryRepoUrl: "https://default", TimeoutShort: 20 * time.Second},
+	}
+	userConfig := UserConfig{
+		Workers:            intPtr(6),
+		Wait:               intPtr(90),
+		VpnCheck:           boolPtr(false),
+		NoProgress:         boolPtr(true),
+		InstallDir:         "/opt/sm2",
+		ArtifactoryRepoUrl: "https://example.com/releases/",
+		JavaHome:           "/usr/lib/jvm/java-21",
+	}
+
+	os.Unsetenv("SM_WORKERS")
+	os.Unsetenv("SM_NOVPN")
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", userConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.Commands.Workers != 6 || sm.Commands.Wait != 90 || !sm.Commands.NoVpnCheck || !sm.Commands.NoProgress {
+		t.Errorf("expected the cli options to be set from sm2.json, got %+v", sm.Commands)
+	}
+	if sm.Config.TmpDir != "/opt/sm2" || sm.Config.ArtifactoryRepoUrl != "https://example.com/releases" || sm.Config.JavaHome != "/usr/lib/jvm/java-21" {
+		t.Errorf("expected the config to be set from sm2.json, got %+v", sm.Config)
+	}
+	if sm.Config.TimeoutShort != 20*time.Second {
+		t.Errorf("expected timeoutShort to be left as the default, got %v", sm.Config.TimeoutShort)
+	}
+
+	out := bytes.Buffer{}
+	sm.ShowConfig(&out)
+	if !strings.Contains(out.String(), "workers") || !strings.Contains(out.String(), "/ws/sm2.json") {
+		t.Errorf("expected the settings to be shown with their source, got:\n%s", out.String())
+	}
+}
+
+func TestCliFlagsOverrideUserConfig(t *testing.T) {
+	sm := ServiceManager{
+		Commands: cli.UserOption{Workers: 3, ExplicitFlags: map[string]bool{"workers": true}},
+	}
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", UserConfig{Workers: intPtr(6)}); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Commands.Workers != 3 {
+		t.Errorf("expected --workers to take precedence, got %d", sm.Commands.Workers)
+	}
+	for _, s := range sm.settings {
+		if s.Name == "workers" && s.Source != "--workers" {
+			t.Errorf("expected the source of workers to be --workers, got %s", s.Source)
+		}
+	}
+}
+
+func TestEnvVarsOverrideUserConfig(t *testing.T) {
+	t.Setenv("SM_TIMEOUT", "5")
+	sm := ServiceManager{Config: ServiceManagerConfig{TimeoutShort: 5 * time.Second}}
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", UserConfig{TimeoutShort: intPtr(60)}); err != nil {
+		t.Fatal(err)
+	}
+	if sm.Config.TimeoutShort != 5*time.Second {
+		t.Errorf("expected SM_TIMEOUT to take precedence, got %v", sm.Config.TimeoutShort)
+	}
+}
+
+func TestUserConfigValidation(t *testing.T) {
+	sm := ServiceManager{}
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", UserConfig{Workers: intPtr(0)}); err == nil {
+		t.Errorf("expected an error for 0 workers")
+	}
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", UserConfig{InstallDir: "relative/path"}); err == nil {
+		t.Errorf("expected an error for a relative installDir")
+	}
+	if err := sm.applyUserConfig("/ws", "/ws/sm2.json", UserConfig{PortRange: "9000"}); err == nil {
+		t.Errorf("expected an error for an invalid portRange")
+	}
+}
+
+func TestLoadUserConfig(t *testing.T) {
+	workspace := t.TempDir()
+	configFile := path.Join(workspace, "sm2.json")
+	os.WriteFile(configFile, []byte(`{"workers": 4, "vpnCheck": false}`), 0644)
+
+	if found := findUserConfig(workspace); found != configFile {
+		t.Errorf("expected to find %s, got %s", configFile, found)
+	}
+
+	userConfig, err := loadUserConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *userConfig.Workers != 4 || *userConfig.VpnCheck != false || userConfig.Wait != nil {
+		t.Errorf("unexpected config %+v", userConfig)
+	}
+
+	os.WriteFile(configFile, []byte(`{"wrokers": 4}`), 0644)
+	if _, err := loadUserConfig(configFile); err == nil {
+		t.Errorf("expected typos to be reported")
+	}
+}
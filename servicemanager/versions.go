@@ -0,0 +1,159 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"sm2/ledger"
+)
+
+// Every version of a service that's been installed is kept side by side in $WORKSPACE/install/SERVICE/VERSION, so
+// switching between them doesn't mean installing them again. The .install file is for the version that was last
+// started, and lists all of them in Versions.
+
+// every version of a service that's installed, including the current one
+func installedVersions(install ledger.InstallFile) []ledger.InstallFile {
+	if len(install.Versions) > 0 {
+		return install.Versions
+	}
+	// installed by an older version of sm2, before versions were kept
+	if install.Path != "" {
+		return []ledger.InstallFile{install}
+	}
+	return nil
+}
+
+// Finds an installed version of a service that can be run. If we're offline and no version was asked for the current
+// install will do, even if it's a local build (from --from-file). Otherwise it has to be the release of the version
+// that was asked for, since a local build of the same version isn't the same thing.
+func findInstalledVersion(install ledger.InstallFile, service string, version string, offline bool) (ledger.InstallFile, bool) {
+	if offline && version == "" {
+		return install, verifyInstall(install, service, version, offline)
+	}
+	versions := append([]ledger.InstallFile{}, installedVersions(install)...)
+	sortNewestFirst(versions)
+	for _, v := range versions {
+		if !v.Local && verifyInstall(v, service, version, offline) {
+			return v, true
+		}
+	}
+	return ledger.InstallFile{}, false
+}
+
+// the dir a version is installed in, checking the version is safe to use as a path
+func versionDir(installDir string, version string) (string, error) {
+	if version == "" || strings.HasPrefix(version, ".") || strings.ContainsAny(version, `/\`) {
+		return "", fmt.Errorf("%s is not a valid version", version)
+	}
+	return path.Join(installDir, version), nil
+}
+
+// Makes a version the current one in the .install file, adding it to the installed versions if it's new. Anything
+// it replaces (e.g. an older install of the same version, or a checkout from --src) is removed. A local build and the
+// release of the same version don't replace each other.
+func (sm *ServiceManager) recordInstall(installDir string, current ledger.InstallFile) error {
+	current.Versions = nil
+	versions := []ledger.InstallFile{current}
+
+	if existing, err := sm.Ledger.LoadInstallFile(installDir); err == nil {
+		for _, v := range installedVersions(existing) {
+			if v.Path == current.Path {
+				continue
+			}
+			if (v.Version == current.Version && v.Local == current.Local) || v.Version == SOURCE {
+				removeVersion(installDir, v)
+				continue
+			}
+			if Exists(v.Path) {
+				versions = append(versions, v)
+			}
+		}
+	}
+
+	current.Versions = versions
+	return sm.Ledger.SaveInstallFile(installDir, current)
+}
+
+// deletes an installed version, and its version dir if it's now empty
+func removeVersion(installDir string, install ledger.InstallFile) error {
+	if !path.IsAbs(install.Path) || !isWithin(installDir, install.Path) || install.Path == installDir {
+		return fmt.Errorf("%s is not inside %s", install.Path, installDir)
+	}
+	if err := os.RemoveAll(install.Path); err != nil {
+		return err
+	}
+	if dir := path.Dir(install.Path); dir != installDir {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// Sorts versions newest first. Ones that aren't semantic versions (local builds etc) are counted as newer than ones
+// that are, and are ordered by when they were installed.
+func sortNewestFirst(versions []ledger.InstallFile) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		iVersion, iErr := parseSemver(versions[i].Version)
+		jVersion, jErr := parseSemver(versions[j].Version)
+		switch {
+		case iErr != nil && jErr != nil:
+			return versions[i].Created.After(versions[j].Created)
+		case iErr != nil || jErr != nil:
+			return iErr != nil
+		}
+		return iVersion.compare(jVersion) > 0
+	})
+}
+
+// The versions to remove to get a service down to its newest N. The one in use (if there is one) is kept too, even if
+// it's older than those.
+func versionsToPrune(install ledger.InstallFile, keep int, inUse string) []ledger.InstallFile {
+	versions := append([]ledger.InstallFile{}, installedVersions(install)...)
+	sortNewestFirst(versions)
+
+	prune := []ledger.InstallFile{}
+	kept := 0
+	for _, v := range versions {
+		if kept < keep {
+			kept++
+			continue
+		}
+		if v.Path != inUse {
+			prune = append(prune, v)
+		}
+	}
+	return prune
+}
+
+// Removes versions from the .install file after they've been deleted. If the current one has gone too the newest
+// one left becomes current.
+func (sm *ServiceManager) forgetVersions(installDir string, removed []ledger.InstallFile) error {
+	install, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		return err
+	}
+	gone := map[string]bool{}
+	for _, v := range removed {
+		gone[v.Path] = true
+	}
+
+	versions := []ledger.InstallFile{}
+	for _, v := range installedVersions(install) {
+		if !gone[v.Path] {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return os.RemoveAll(installDir)
+	}
+
+	current := install
+	if gone[install.Path] {
+		sortNewestFirst(versions)
+		current = versions[0]
+	}
+	current.Versions = versions
+	return sm.Ledger.SaveInstallFile(installDir, current)
+}
@@ -0,0 +1,203 @@
+package servicemanager
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+// installs each version of playtest from a stub repo that has them all
+func installVersions(t *testing.T, versions ...string) (*ServiceManager, string) {
+	t.Helper()
+	sm := repoServiceManager(t, localRepo(t, versions...))
+	sm.Config.TmpDir = t.TempDir()
+	installDir := path.Join(sm.Config.TmpDir, "playtest")
+	for _, version := range versions {
+		if _, err := sm.installService(installDir, "PLAYTEST", "foo.bar", "playtest", version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sm, installDir
+}
+
+// a file:// repo with the test tarball published as each version
+func localRepo(t *testing.T, versions ...string) string {
+	t.Helper()
+	repo := t.TempDir()
+	tgz, err := os.ReadFile("../testing/testdata/playtest-1.0.0.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		dir := path.Join(repo, "foo", "bar", "playtest", version)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(path.Join(dir, "playtest-"+version+".tgz"), tgz, 0644)
+	}
+	return "file://" + repo
+}
+
+func installedVersionNames(install ledger.InstallFile) map[string]bool {
+	names := map[string]bool{}
+	for _, v := range installedVersions(install) {
+		names[v.Version] = true
+	}
+	return names
+}
+
+func TestInstallingAVersionKeepsTheOthers(t *testing.T) {
+	sm, installDir := installVersions(t, "1.0.0", "1.1.0")
+
+	install, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if install.Version != "1.1.0" {
+		t.Errorf("expected the last version installed to be current, got %s", install.Version)
+	}
+	if names := installedVersionNames(install); len(names) != 2 || !names["1.0.0"] || !names["1.1.0"] {
+		t.Errorf("expected both versions to be installed, got %v", names)
+	}
+
+	old, ok := findInstalledVersion(install, "PLAYTEST", "1.0.0", false)
+	if !ok || old.Path != path.Join(installDir, "1.0.0", "playtest-1.0.0") || !Exists(old.Path) {
+		t.Errorf("expected 1.0.0 to still be installed in its own dir, got %s", old.Path)
+	}
+
+	// switching back doesn't need a reinstall
+	if err := sm.recordInstall(installDir, old); err != nil {
+		t.Fatal(err)
+	}
+	install, _ = sm.Ledger.LoadInstallFile(installDir)
+	if install.Version != "1.0.0" || len(installedVersions(install)) != 2 {
+		t.Errorf("expected 1.0.0 to be current with both still installed, got %s %v", install.Version, installedVersionNames(install))
+	}
+}
+
+func TestFindInstalledVersionOffline(t *testing.T) {
+	sm, installDir := installVersions(t, "1.0.0", "1.1.0")
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+
+	if current, ok := findInstalledVersion(install, "PLAYTEST", "", true); !ok || current.Version != "1.1.0" {
+		t.Errorf("expected --offline without a version to use the current one, got %s", current.Version)
+	}
+	if old, ok := findInstalledVersion(install, "PLAYTEST", "1.0.0", true); !ok || old.Version != "1.0.0" {
+		t.Errorf("expected --offline to find 1.0.0, got %s", old.Version)
+	}
+	if _, ok := findInstalledVersion(install, "PLAYTEST", "2.0.0", true); ok {
+		t.Errorf("expected --offline not to use a different version to the one asked for")
+	}
+}
+
+func TestReinstallingAnOldLayoutInstallReplacesIt(t *testing.T) {
+	sm := repoServiceManager(t, localRepo(t, "1.0.0"))
+	sm.Config.TmpDir = t.TempDir()
+	installDir := path.Join(sm.Config.TmpDir, "playtest")
+
+	// installed by an older sm2, straight into the install dir
+	oldPath := path.Join(installDir, "playtest-1.0.0")
+	os.MkdirAll(oldPath, 0755)
+	sm.Ledger.SaveInstallFile(installDir, ledger.InstallFile{Service: "PLAYTEST", Version: "1.0.0", Path: oldPath})
+
+	installFile, err := sm.installService(installDir, "PLAYTEST", "foo.bar", "playtest", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Exists(oldPath) {
+		t.Errorf("expected the old install of 1.0.0 to be removed")
+	}
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+	if versions := installedVersions(install); len(versions) != 1 || versions[0].Path != installFile.Path {
+		t.Errorf("expected only the new install to be recorded, got %v", versions)
+	}
+}
+
+func TestVersionDirRejectsUnsafeVersions(t *testing.T) {
+	for _, version := range []string{"", "..", "../../etc", "1.0/2", ".install"} {
+		if _, err := versionDir("/tmp/install/foo", version); err == nil {
+			t.Errorf("expected %q to be rejected", version)
+		}
+	}
+	if dir, err := versionDir("/tmp/install/foo", "1.2.3"); err != nil || dir != "/tmp/install/foo/1.2.3" {
+		t.Errorf("expected 1.2.3 to be installed in /tmp/install/foo/1.2.3, got %s (%v)", dir, err)
+	}
+}
+
+func TestSortNewestFirst(t *testing.T) {
+	now := time.Now()
+	versions := []ledger.InstallFile{
+		{Version: "1.2.0"},
+		{Version: "1.10.0"},
+		{Version: "0.9.9"},
+		{Version: "local", Created: now.Add(-time.Hour)},
+		{Version: "2.0.0-SNAPSHOT", Created: now},
+	}
+	sortNewestFirst(versions)
+
+	expected := []string{"local", "2.0.0-SNAPSHOT", "1.10.0", "1.2.0", "0.9.9"}
+	for i, v := range versions {
+		if v.Version != expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, v.Version)
+		}
+	}
+}
+
+func TestCleanCacheKeepsNewestVersions(t *testing.T) {
+	sm, installDir := installVersions(t, "1.0.0", "1.2.0", "1.1.0", "1.3.0")
+	sm.Commands.Verbose = true // Skip confirmation prompt
+	sm.Commands.KeepVersions = 2
+
+	// 1.0.0 is running
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+	running, _ := findInstalledVersion(install, "PLAYTEST", "1.0.0", false)
+	sm.Ledger.SaveStateFile(installDir, ledger.StateFile{Service: "PLAYTEST", Version: "1.0.0", Path: running.Path, Pid: os.Getpid()})
+	sm.Platform = platform.Platform{PidLookup: func() map[int]int { return map[int]int{os.Getpid(): os.Getpid()} }}
+
+	if err := sm.CleanCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	install, _ = sm.Ledger.LoadInstallFile(installDir)
+	names := installedVersionNames(install)
+	if len(names) != 3 || !names["1.3.0"] || !names["1.2.0"] || !names["1.0.0"] {
+		t.Errorf("expected the newest 2 versions and the running one to be kept, got %v", names)
+	}
+	if Exists(path.Join(installDir, "1.1.0")) {
+		t.Errorf("expected 1.1.0 to be deleted")
+	}
+	if !Exists(running.Path) {
+		t.Errorf("expected the running version to be kept")
+	}
+}
+
+func TestInstallingFromSourceKeepsTheOtherVersions(t *testing.T) {
+	repo := t.TempDir()
+	for _, args := range [][]string{{"init", "-q"}, {"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git isn't available: %s %s", err, out)
+		}
+	}
+
+	sm, installDir := installVersions(t, "1.0.0")
+	installFile, err := sm.installFromGit(installDir, "file://"+repo, Service{Id: "PLAYTEST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.recordInstall(installDir, installFile); err != nil {
+		t.Fatal(err)
+	}
+
+	install, _ := sm.Ledger.LoadInstallFile(installDir)
+	if names := installedVersionNames(install); len(names) != 2 || !names["1.0.0"] || !names[SOURCE] {
+		t.Errorf("expected 1.0.0 to be kept alongside the source, got %v", names)
+	}
+	if !Exists(path.Join(installDir, "1.0.0")) || installFile.Path != path.Join(installDir, SOURCE, "src") {
+		t.Errorf("expected the source to be cloned into its own dir, got %s", installFile.Path)
+	}
+}
@@ -16,12 +16,12 @@ func checkVpn(client *http.Client, config ServiceManagerConfig) (bool, error) {
 	// cleanup the context
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", config.ArtifactoryPingUrl, nil)
+	req, err := newArtifactoryRequest(ctx, config, config.ArtifactoryPingUrl)
 	if err != nil {
 		return false, err
 	}
 
-	resp, err := client.Do(req)
+	resp, err := artifactoryClient(client).Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -22,6 +22,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	// sm2 writes the logs of the services it starts, see servicemanager.startLogWriter
+	if cmds.WriteLog != "" {
+		if err := servicemanager.WriteLog(os.Stdin, cmds.WriteLog); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	client := &http.Client{
 		Timeout: 30 * time.Minute,
 	}